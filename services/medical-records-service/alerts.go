@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+// alertChannel delivers critical lab result alerts. Channels are pluggable:
+// implement Send and register the channel in loadAlertChannels to route
+// alerts to a new system such as PagerDuty.
+type alertChannel interface {
+	Send(event criticalLabEvent)
+}
+
+var alertChannels []alertChannel
+
+// loadAlertChannels returns the configured alert channels. Alerts are
+// always logged; webhooks are added when any are registered.
+func loadAlertChannels() []alertChannel {
+	channels := []alertChannel{logAlertChannel{}}
+	if len(webhooks.URLs) > 0 {
+		channels = append(channels, webhookAlertChannel{})
+	}
+	return channels
+}
+
+// logAlertChannel writes each alert as a structured WARN log entry.
+type logAlertChannel struct{}
+
+func (logAlertChannel) Send(event criticalLabEvent) {
+	patientID := event.PatientID
+	if redactPHI {
+		patientID = redactValue(patientID)
+	}
+	logger.WithFields(logrus.Fields{
+		"record_id":  event.RecordID,
+		"patient_id": patientID,
+		"doctor_id":  event.DoctorID,
+		"test_name":  event.LabResult.TestName,
+		"test_code":  event.LabResult.TestCode,
+	}).Warn("Critical lab result recorded")
+}
+
+// webhookAlertChannel posts each alert to every registered webhook in the
+// background.
+type webhookAlertChannel struct{}
+
+func (webhookAlertChannel) Send(event criticalLabEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.WithError(err).Error("Failed to encode critical lab result event")
+		return
+	}
+
+	for _, url := range webhooks.URLs {
+		url := url
+		goBackground(func() {
+			deliverWebhook(url, payload)
+		})
+	}
+}
+
+// detectCriticalResults returns the record's lab results with status
+// critical.
+func detectCriticalResults(record MedicalRecord) []LabResult {
+	var critical []LabResult
+	for _, result := range record.LabResults {
+		if result.Status == "critical" {
+			critical = append(critical, result)
+		}
+	}
+	return critical
+}
+
+// newCriticalResults returns the critical results in updated that were not
+// already critical in previous, so an update does not re-alert on results
+// that were alerted when first recorded.
+func newCriticalResults(previous, updated MedicalRecord) []LabResult {
+	// NumericValue is a pointer, so results are compared without it; it is
+	// derived from Result anyway
+	key := func(result LabResult) LabResult {
+		result.NumericValue = nil
+		return result
+	}
+	seen := make(map[LabResult]bool)
+	for _, result := range detectCriticalResults(previous) {
+		seen[key(result)] = true
+	}
+
+	var fresh []LabResult
+	for _, result := range detectCriticalResults(updated) {
+		if !seen[key(result)] {
+			fresh = append(fresh, result)
+		}
+	}
+	return fresh
+}
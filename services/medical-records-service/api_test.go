@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testJWTSecret = "test-secret"
+
+// testCaller is who a test request authenticates as.
+type testCaller struct {
+	user         string
+	role         string
+	organization string
+}
+
+var (
+	testDoctor      = testCaller{user: "DOC-1", role: "doctor", organization: "CLINIC-A"}
+	testOtherDoctor = testCaller{user: "DOC-2", role: "doctor", organization: "CLINIC-A"}
+	testNurse       = testCaller{user: "NURSE-1", role: "nurse", organization: "CLINIC-A"}
+	testAdmin       = testCaller{user: "ADMIN-1", role: "admin", organization: "CLINIC-A"}
+	testOtherClinic = testCaller{user: "DOC-9", role: "doctor", organization: "CLINIC-B"}
+)
+
+// context returns a context scoped to the caller's organization, for
+// seeding and inspecting the store directly.
+func (caller testCaller) context() context.Context {
+	return withOrganization(context.Background(), caller.organization)
+}
+
+// testAPI serves the API with authentication on, over an in-memory store.
+type testAPI struct {
+	t      *testing.T
+	store  *memRecordStore
+	router *gin.Engine
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", testJWTSecret)
+
+	previousStore, previousOutput := recordStore, logger.Out
+	store := newMemRecordStore()
+	recordStore = store
+	logger.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		recordStore = previousStore
+		logger.SetOutput(previousOutput)
+	})
+	return &testAPI{t: t, store: store, router: setupRouter()}
+}
+
+// do sends a request as caller, with body encoded as JSON unless it is nil.
+func (api *testAPI) do(caller testCaller, method, path string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.doWithHeaders(caller, method, path, body, nil)
+}
+
+// doWithHeaders is do with extra request headers.
+func (api *testAPI) doWithHeaders(caller testCaller, method, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	api.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			api.t.Fatalf("encode request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return api.serve(caller, req)
+}
+
+// serve sends req as caller, for requests do cannot build.
+func (api *testAPI) serve(caller testCaller, req *http.Request) *httptest.ResponseRecorder {
+	api.t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, authClaims{
+		UserID:         caller.user,
+		Role:           caller.role,
+		OrganizationID: caller.organization,
+	}).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		api.t.Fatalf("sign token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	api.router.ServeHTTP(w, req)
+	return w
+}
+
+// seed stores record in the caller's organization, filling in what a
+// create would, and returns it as stored.
+func (api *testAPI) seed(caller testCaller, record MedicalRecord) MedicalRecord {
+	api.t.Helper()
+
+	if record.PatientID == "" {
+		record.PatientID = "PAT-1"
+	}
+	if record.DoctorID == "" {
+		record.DoctorID = caller.user
+	}
+	if record.RecordType == "" {
+		record.RecordType = "consultation"
+	}
+	if record.Title == "" {
+		record.Title = "Checkup"
+	}
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = record.CreatedAt
+	}
+	if err := api.store.Create(caller.context(), &record); err != nil {
+		api.t.Fatalf("seed record: %v", err)
+	}
+	stored, err := api.store.Get(caller.context(), record.ID)
+	if err != nil {
+		api.t.Fatalf("load seeded record: %v", err)
+	}
+	return stored
+}
+
+// stored loads a record straight from the store.
+func (api *testAPI) stored(caller testCaller, id primitive.ObjectID) (MedicalRecord, error) {
+	return api.store.Get(caller.context(), id)
+}
+
+// expectStatus fails the test unless w has status want.
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, want, w.Body.String())
+	}
+}
+
+// decodeBody decodes the JSON response body of w.
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+		t.Fatalf("decode response %s: %v", w.Body.String(), err)
+	}
+	return value
+}
+
+// recordURL is the API path of a record.
+func recordURL(id primitive.ObjectID) string {
+	return "/api/v1/medical-records/" + id.Hex()
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// attachmentDir is where uploaded attachment files are stored, one
+	// subdirectory per record, each file under a unique prefix.
+	attachmentDir string
+
+	// maxAttachmentsPerRecord and maxAttachmentBytesPerRecord cap how many
+	// attachments a record may hold and their combined size
+	// (MAX_ATTACHMENTS_PER_RECORD, MAX_ATTACHMENT_BYTES_PER_RECORD).
+	maxAttachmentsPerRecord     int
+	maxAttachmentBytesPerRecord int64
+
+	// attachmentScanner scans uploads for malware. It is nil when
+	// neither CLAMAV_ADDRESS nor CLAMAV_ADDR is set, in which case scanning
+	// is skipped.
+	attachmentScanner *ClamAVScanner
+)
+
+// allowedAttachmentTypes are the accepted attachment file types, by MIME
+// type or short name.
+var allowedAttachmentTypes = map[string]bool{
+	"application/pdf":   true,
+	"image/jpeg":        true,
+	"image/png":         true,
+	"application/dicom": true,
+	"pdf":               true,
+	"jpeg":              true,
+	"png":               true,
+	"dicom":             true,
+}
+
+// allowedAttachmentType reports whether fileType is an accepted type.
+// MIME parameters such as charset are ignored.
+func allowedAttachmentType(fileType string) bool {
+	mediaType, _, _ := strings.Cut(fileType, ";")
+	return allowedAttachmentTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+}
+
+// AttachmentError reports an embedded attachment with invalid metadata.
+type AttachmentError struct {
+	FileName string
+	Field    string
+	Reason   string
+}
+
+func (e *AttachmentError) Error() string {
+	return fmt.Sprintf("attachment %q: %s %s", e.FileName, e.Field, e.Reason)
+}
+
+// validateAttachments checks the type and size of attachments embedded in
+// a record body. Sizes must be positive and no larger than an upload may
+// be (MAX_UPLOAD_BYTES), and there may be at most maxAttachmentsPerRecord.
+func validateAttachments(attachments []Attachment) error {
+	if len(attachments) > maxAttachmentsPerRecord {
+		return fmt.Errorf("a record may hold at most %d attachments", maxAttachmentsPerRecord)
+	}
+	for _, attachment := range attachments {
+		if !allowedAttachmentType(attachment.FileType) {
+			return &AttachmentError{FileName: attachment.FileName, Field: "file_type", Reason: "must be one of pdf, jpeg, png or dicom"}
+		}
+		if attachment.FileSize <= 0 {
+			return &AttachmentError{FileName: attachment.FileName, Field: "file_size", Reason: "must be greater than zero"}
+		}
+		if attachment.FileSize > maxUploadBytes {
+			return &AttachmentError{FileName: attachment.FileName, Field: "file_size", Reason: fmt.Sprintf("must not exceed %d bytes", maxUploadBytes)}
+		}
+	}
+	return nil
+}
+
+// respondAttachmentError writes a 400 naming the offending attachment.
+func respondAttachmentError(c *gin.Context, err error) {
+	attachmentErr, ok := err.(*AttachmentError)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "field": "attachments"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":     attachmentErr.Error(),
+		"file_name": attachmentErr.FileName,
+		"field":     "attachments." + attachmentErr.Field,
+	})
+}
+
+// AttachmentLimitError reports an upload a record has no room for: its file
+// name is taken, or it would exceed maxAttachmentsPerRecord or
+// maxAttachmentBytesPerRecord.
+type AttachmentLimitError struct {
+	// Limit is duplicate_name, max_attachments or max_total_bytes
+	Limit string
+	Max   int64
+	Used  int64
+}
+
+func (e *AttachmentLimitError) Error() string {
+	switch e.Limit {
+	case "duplicate_name":
+		return "An attachment with this file name already exists"
+	case "max_attachments":
+		return "Record has reached the maximum number of attachments"
+	}
+	return "Attachment would exceed the record's total attachment size"
+}
+
+// checkAttachmentRoom returns an *AttachmentLimitError unless record can
+// take an attachment named fileName of size bytes.
+func checkAttachmentRoom(record MedicalRecord, fileName string, size int64) error {
+	var totalBytes int64
+	for _, existing := range record.Attachments {
+		if existing.FileName == fileName {
+			return &AttachmentLimitError{Limit: "duplicate_name"}
+		}
+		totalBytes += existing.FileSize
+	}
+	if len(record.Attachments) >= maxAttachmentsPerRecord {
+		return &AttachmentLimitError{Limit: "max_attachments", Max: int64(maxAttachmentsPerRecord)}
+	}
+	if totalBytes+size > maxAttachmentBytesPerRecord {
+		return &AttachmentLimitError{Limit: "max_total_bytes", Max: maxAttachmentBytesPerRecord, Used: totalBytes}
+	}
+	return nil
+}
+
+// respondAttachmentLimit writes a 409 naming the limit an upload hit.
+func respondAttachmentLimit(c *gin.Context, err *AttachmentLimitError) {
+	switch err.Limit {
+	case "duplicate_name":
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case "max_attachments":
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "limit": err.Limit, "max": err.Max})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "limit": err.Limit, "max": err.Max, "used": err.Used})
+	}
+}
+
+// uploadAttachment stores a multipart "file" upload and appends its metadata
+// to the record's attachments.
+//
+// @Summary Upload an attachment
+// @Description Accepts PDF, JPEG, PNG and DICOM files.
+// @Tags Attachments
+// @Accept mpfd
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param file formData file true "The file"
+// @Param description formData string false "What the file is"
+// @Success 201 {object} Attachment
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 409 {object} apiError "Duplicate file name or attachment limits reached"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed the malware scan"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The malware scanner is unavailable"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/attachments [post]
+func uploadAttachment(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		if isBodyTooLarge(err) {
+			logBodyTooLarge(c, maxUploadBytes)
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachment too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	fileName := filepath.Base(header.Filename)
+	if fileName == "." || fileName == string(filepath.Separator) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file name"})
+		return
+	}
+	if !allowedAttachmentType(header.Header.Get("Content-Type")) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "Attachment file type must be one of pdf, jpeg, png or dicom",
+			"file_name": fileName,
+		})
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	record, err := findRecord(ctx, objectID)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+			return
+		}
+		logger.WithError(err).Error("Failed to fetch medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch record"})
+		return
+	}
+	// Checked again when the attachment is added; this one saves storing
+	// a file that would be rejected
+	var lockErr *RecordLockedError
+	if errors.As(checkRecordLock(record, currentUser(c)), &lockErr) {
+		respondRecordLocked(c, lockErr)
+		return
+	}
+	// Checked again against the record the attachment is added to, which
+	// a concurrent upload may have changed since
+	var limitErr *AttachmentLimitError
+	if errors.As(checkAttachmentRoom(record, fileName, header.Size), &limitErr) {
+		respondAttachmentLimit(c, limitErr)
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	defer file.Close()
+
+	// Scan before anything is persisted
+	if attachmentScanner != nil {
+		result, err := attachmentScanner.Scan(ctx, file)
+		if err != nil {
+			logger.WithError(err).Error("Failed to scan attachment")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Attachment could not be scanned"})
+			return
+		}
+		if result.Infected {
+			logger.WithField("record_id", objectID.Hex()).WithField("signature", result.Signature).Warn("Rejected infected attachment")
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Attachment failed malware scan", "signature": result.Signature})
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+			return
+		}
+	}
+
+	// A unique prefix keeps the file apart from earlier uploads under the
+	// same name, including ones since removed from the record and uploads
+	// racing this one
+	storagePath := filepath.Join(attachmentDir, objectID.Hex(), primitive.NewObjectID().Hex()+"-"+fileName)
+	size, checksum, err := saveAttachmentFile(storagePath, file)
+	if err != nil {
+		logger.WithError(err).Error("Failed to store attachment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+		return
+	}
+
+	attachment := Attachment{
+		FileName:    fileName,
+		FileType:    header.Header.Get("Content-Type"),
+		FileSize:    size,
+		StoragePath: storagePath,
+		UploadedAt:  time.Now().UTC(),
+		Description: c.PostForm("description"),
+		Checksum:    checksum,
+	}
+
+	updated, err := applyRecordUpdate(ctx, objectID, currentUser(c), func(record *MedicalRecord) error {
+		if err := checkAttachmentRoom(*record, fileName, size); err != nil {
+			return err
+		}
+		record.Attachments = append(record.Attachments, attachment)
+		return nil
+	})
+	if err != nil {
+		os.Remove(storagePath)
+		if errors.As(err, &lockErr) {
+			respondRecordLocked(c, lockErr)
+			return
+		}
+		if errors.As(err, &limitErr) {
+			respondAttachmentLimit(c, limitErr)
+			return
+		}
+		logger.WithError(err).Error("Failed to add attachment to medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add attachment"})
+		return
+	}
+	if warnings, _ := checkRecordSize(updated); len(warnings) > 0 {
+		logger.WithField("record_id", objectID.Hex()).Warn(warnings[0].Message)
+	}
+
+	logger.WithField("record_id", objectID.Hex()).Info("Attachment uploaded successfully")
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// saveAttachmentFile writes r to path, creating parent directories, and
+// returns the number of bytes written and their hex SHA-256 checksum.
+func saveAttachmentFile(path string, r io.Reader) (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, "", err
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileChecksum returns the hex SHA-256 checksum of the file at path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+var (
+	errAttachmentUnverified = errors.New("attachment has no checksum")
+	errAttachmentOutside    = errors.New("attachment path is outside the attachment directory")
+	errAttachmentCorrupted  = errors.New("attachment checksum mismatch")
+)
+
+// withStoredAttachmentFields returns attachments with the server-owned
+// storage path and checksum copied from the stored attachment of the same
+// name, or cleared when there is none, so a request body can describe
+// attachments but never point one at a file.
+func withStoredAttachmentFields(attachments, stored []Attachment) []Attachment {
+	for i := range attachments {
+		attachments[i].StoragePath = ""
+		attachments[i].Checksum = ""
+		for _, s := range stored {
+			if s.FileName == attachments[i].FileName {
+				attachments[i].StoragePath = s.StoragePath
+				attachments[i].Checksum = s.Checksum
+				break
+			}
+		}
+	}
+	return attachments
+}
+
+// storedAttachmentPath returns the cleaned path of an attachment's file,
+// refusing paths that resolve outside attachmentDir.
+func storedAttachmentPath(attachment Attachment) (string, error) {
+	root, err := filepath.Abs(attachmentDir)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.Abs(attachment.StoragePath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errAttachmentOutside
+	}
+	return path, nil
+}
+
+// verifiedAttachmentPath is storedAttachmentPath for a file that still
+// matches its upload checksum. Attachments without a checksum cannot be
+// verified and are refused.
+func verifiedAttachmentPath(attachment Attachment) (string, error) {
+	if attachment.Checksum == "" {
+		return "", errAttachmentUnverified
+	}
+	path, err := storedAttachmentPath(attachment)
+	if err != nil {
+		return "", err
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+	if checksum != attachment.Checksum {
+		return "", errAttachmentCorrupted
+	}
+	return path, nil
+}
+
+// findAttachment loads the attachment named by the filename path parameter,
+// writing the error response itself when it cannot be found.
+func findAttachment(c *gin.Context) (Attachment, bool) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return Attachment{}, false
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	record, err := findRecord(ctx, objectID)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+			return Attachment{}, false
+		}
+		logger.WithError(err).Error("Failed to fetch medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch record"})
+		return Attachment{}, false
+	}
+
+	fileName := c.Param("filename")
+	for _, attachment := range record.Attachments {
+		if attachment.FileName == fileName {
+			return attachment, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+	return Attachment{}, false
+}
+
+// downloadAttachment serves an attachment after checking it lies inside
+// attachmentDir and still matches the checksum taken at upload, so
+// corrupted or foreign files are never handed out. Attachments without a
+// checksum are refused.
+//
+// @Summary Download an attachment
+// @Tags Attachments
+// @Produce octet-stream
+// @Param id path string true "Record ID"
+// @Param filename path string true "Attachment file name"
+// @Success 200 {file} file
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record or attachment not found"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/attachments/{filename} [get]
+func downloadAttachment(c *gin.Context) {
+	attachment, ok := findAttachment(c)
+	if !ok {
+		return
+	}
+
+	path, err := verifiedAttachmentPath(attachment)
+	switch {
+	case errors.Is(err, errAttachmentUnverified):
+		c.JSON(http.StatusConflict, gin.H{"error": "No checksum was recorded for this attachment"})
+		return
+	case errors.Is(err, errAttachmentOutside), errors.Is(err, errAttachmentCorrupted):
+		logger.WithError(err).WithField("path", attachment.StoragePath).Error("Attachment failed integrity check")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Attachment failed integrity check"})
+		return
+	case err != nil:
+		logger.WithError(err).WithField("path", attachment.StoragePath).Error("Failed to read attachment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+		return
+	}
+
+	c.FileAttachment(path, attachment.FileName)
+}
+
+// verifyAttachment reports whether a stored attachment still matches its
+// upload checksum without sending the file.
+//
+// @Summary Check an attachment against its upload checksum
+// @Tags Attachments
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param filename path string true "Attachment file name"
+// @Success 200 {object} object{file_name=string,valid=bool,expected_checksum=string,actual_checksum=string,error=string}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record or attachment not found"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/attachments/{filename}/verify [get]
+func verifyAttachment(c *gin.Context) {
+	attachment, ok := findAttachment(c)
+	if !ok {
+		return
+	}
+	if attachment.Checksum == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"file_name": attachment.FileName,
+			"valid":     false,
+			"error":     "No checksum was recorded for this attachment",
+		})
+		return
+	}
+
+	response := gin.H{
+		"file_name":         attachment.FileName,
+		"expected_checksum": attachment.Checksum,
+	}
+	path, err := storedAttachmentPath(attachment)
+	if err != nil {
+		logger.WithError(err).WithField("path", attachment.StoragePath).Error("Attachment failed integrity check")
+		response["valid"] = false
+		response["error"] = "Attachment file is outside the attachment store"
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		logger.WithError(err).WithField("path", attachment.StoragePath).Error("Failed to read attachment")
+		response["valid"] = false
+		response["error"] = "Attachment file could not be read"
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	response["actual_checksum"] = checksum
+	response["valid"] = checksum == attachment.Checksum
+	if checksum != attachment.Checksum {
+		logger.WithField("path", attachment.StoragePath).Error("Attachment checksum mismatch")
+	}
+	c.JSON(http.StatusOK, response)
+}
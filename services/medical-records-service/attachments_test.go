@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadAttachmentRechecksLimitsOnWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		secondName string
+		maxCount   int
+		maxBytes   int64
+		wantLimit  string
+	}{
+		{name: "attachment count", secondName: "second.pdf", maxCount: 1, maxBytes: 1 << 20, wantLimit: "max_attachments"},
+		{name: "total size", secondName: "second.pdf", maxCount: 10, maxBytes: 100, wantLimit: "max_total_bytes"},
+		{name: "file name", secondName: "first.pdf", maxCount: 10, maxBytes: 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousDir, previousCount, previousBytes := attachmentDir, maxAttachmentsPerRecord, maxAttachmentBytesPerRecord
+			attachmentDir, maxAttachmentsPerRecord, maxAttachmentBytesPerRecord = t.TempDir(), tt.maxCount, tt.maxBytes
+			t.Cleanup(func() {
+				attachmentDir, maxAttachmentsPerRecord, maxAttachmentBytesPerRecord = previousDir, previousCount, previousBytes
+			})
+
+			api := newTestAPI(t)
+			record := api.seed(testDoctor, MedicalRecord{})
+			// Another upload lands after this one passed the early checks
+			recordStore = newInterleavedStore(api.store, func() {
+				w := api.serve(testOtherDoctor, uploadFileRequest(t, record, "first.pdf", 64))
+				expectStatus(t, w, http.StatusCreated)
+			})
+
+			w := api.serve(testDoctor, uploadFileRequest(t, record, tt.secondName, 64))
+			expectStatus(t, w, http.StatusConflict)
+			if got := decodeBody[map[string]interface{}](t, w)["limit"]; tt.wantLimit != "" && got != tt.wantLimit {
+				t.Errorf("limit = %v, want %s", got, tt.wantLimit)
+			}
+
+			stored, err := api.stored(testDoctor, record.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored.Attachments) != 1 || stored.Attachments[0].FileName != "first.pdf" {
+				t.Errorf("stored attachments = %+v, want only first.pdf", stored.Attachments)
+			}
+			// The rejected upload leaves no file behind
+			files, err := os.ReadDir(filepath.Join(attachmentDir, record.ID.Hex()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 {
+				t.Errorf("stored files = %d, want 1", len(files))
+			}
+		})
+	}
+}
+
+func TestUploadAttachmentAfterRemovingOneOfTheSameName(t *testing.T) {
+	previousDir := attachmentDir
+	attachmentDir = t.TempDir()
+	t.Cleanup(func() { attachmentDir = previousDir })
+
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{})
+
+	expectStatus(t, api.serve(testDoctor, uploadRequest(t, record, 64)), http.StatusCreated)
+	body := recordUpdate("Checkup")
+	body["attachments"] = []Attachment{}
+	expectStatus(t, api.do(testDoctor, http.MethodPut, recordURL(record.ID), body), http.StatusOK)
+
+	// The removed attachment's file is still on disk
+	expectStatus(t, api.serve(testDoctor, uploadRequest(t, record, 64)), http.StatusCreated)
+	w := api.do(testDoctor, http.MethodGet, recordURL(record.ID)+"/attachments/scan.pdf", nil)
+	expectStatus(t, w, http.StatusOK)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// auditCollection records who performed sensitive operations and when.
+const auditCollection = "audit_log"
+
+type AuditEntry struct {
+	ID             primitive.ObjectID     `bson:"_id,omitempty" json:"id,omitempty"`
+	Action         string                 `bson:"action" json:"action"`
+	Actor          string                 `bson:"actor" json:"actor"`
+	OrganizationID string                 `bson:"organization_id" json:"organization_id"`
+	RecordID       string                 `bson:"record_id,omitempty" json:"record_id,omitempty"`
+	PatientID      string                 `bson:"patient_id,omitempty" json:"patient_id,omitempty"`
+	Details        map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	ClientIP       string                 `bson:"client_ip" json:"client_ip"`
+	Timestamp      time.Time              `bson:"timestamp" json:"timestamp"`
+}
+
+// newAuditEntry starts an audit entry for action attributed to the caller.
+func newAuditEntry(c *gin.Context, action string) AuditEntry {
+	return AuditEntry{
+		Action:         action,
+		Actor:          currentUser(c),
+		OrganizationID: currentOrganization(c),
+		ClientIP:       c.ClientIP(),
+		Timestamp:      time.Now().UTC(),
+	}
+}
+
+// writeAudit stores entry. Pass the transaction context to have it commit
+// or roll back with the audited change.
+func writeAudit(ctx context.Context, entry AuditEntry) error {
+	return recordStore.WriteAudit(ctx, entry)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// maxBodyBytes caps JSON request bodies (MAX_BODY_BYTES).
+	maxBodyBytes int64
+
+	// maxUploadBytes caps attachment uploads (MAX_UPLOAD_BYTES).
+	maxUploadBytes int64
+)
+
+// contextBodyLimit holds the body limit applied to the current request.
+const contextBodyLimit = "body_limit"
+
+// logBodyTooLarge records a rejected oversized body so abuse is visible.
+func logBodyTooLarge(c *gin.Context, limit int64) {
+	logger.WithFields(logrus.Fields{
+		"method":         c.Request.Method,
+		"route":          c.FullPath(),
+		"content_length": c.Request.ContentLength,
+		"limit":          limit,
+		"client_ip":      c.ClientIP(),
+	}).Warn("Rejected oversized request body")
+}
+
+// bodyLimitMiddleware rejects bodies larger than limit with 413. Declared
+// oversized bodies are rejected up front; others are cut off while being
+// read so a large payload is never fully buffered.
+func bodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			logBodyTooLarge(c, limit)
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Set(contextBodyLimit, limit)
+		c.Next()
+	}
+}
+
+// isBodyTooLarge reports whether err came from exceeding the body limit.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// respondBindError writes the response for a failed request body bind: 413
+// when the body limit was hit, 422 when the JSON parsed but failed binding
+// validation and 400 for malformed JSON.
+func respondBindError(c *gin.Context, err error) {
+	if isBodyTooLarge(err) {
+		logBodyTooLarge(c, c.GetInt64(contextBodyLimit))
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		return
+	}
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		respondValidationError(c, err)
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// limitBodies sets small body limits for the routers built after it.
+func limitBodies(t *testing.T, body, upload int64) {
+	t.Helper()
+	previousBody, previousUpload := maxBodyBytes, maxUploadBytes
+	maxBodyBytes, maxUploadBytes = body, upload
+	t.Cleanup(func() { maxBodyBytes, maxUploadBytes = previousBody, previousUpload })
+}
+
+// uploadRequest builds a multipart upload of a size-byte PDF to record.
+func uploadRequest(t *testing.T, record MedicalRecord, size int) *http.Request {
+	t.Helper()
+	return uploadFileRequest(t, record, "scan.pdf", size)
+}
+
+// uploadFileRequest is uploadRequest with the PDF named fileName.
+func uploadFileRequest(t *testing.T, record MedicalRecord, fileName string, size int) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+fileName+`"`)
+	header.Set("Content-Type", "application/pdf")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte("x"), size))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, recordURL(record.ID)+"/attachments", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestBodyLimit(t *testing.T) {
+	limitBodies(t, 1<<10, 8<<10)
+	previousDir := attachmentDir
+	attachmentDir = t.TempDir()
+	t.Cleanup(func() { attachmentDir = previousDir })
+
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{})
+
+	t.Run("oversized JSON body", func(t *testing.T) {
+		body := recordUpdate(strings.Repeat("x", 2<<10))
+		w := api.do(testDoctor, http.MethodPost, "/api/v1/medical-records", body)
+		expectStatus(t, w, http.StatusRequestEntityTooLarge)
+	})
+	t.Run("upload over the JSON limit", func(t *testing.T) {
+		w := api.serve(testDoctor, uploadRequest(t, record, 4<<10))
+		expectStatus(t, w, http.StatusCreated)
+	})
+	t.Run("upload over its own limit", func(t *testing.T) {
+		w := api.serve(testDoctor, uploadRequest(t, record, 16<<10))
+		expectStatus(t, w, http.StatusRequestEntityTooLarge)
+	})
+}
+
+func TestWriteEndpointsRejectStreamedOversizedBodies(t *testing.T) {
+	limitBodies(t, 1<<10, 8<<10)
+
+	tests := []struct {
+		name   string
+		method string
+		path   func(record MedicalRecord) string
+	}{
+		{name: "create", method: http.MethodPost, path: func(MedicalRecord) string { return "/api/v1/medical-records" }},
+		{name: "update", method: http.MethodPut, path: func(record MedicalRecord) string { return recordURL(record.ID) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			record := api.seed(testDoctor, MedicalRecord{})
+
+			// No declared length, so the limit is only hit while reading
+			body := `{"patient_id":"PAT-1","title":"` + strings.Repeat("x", 4<<10) + `"}`
+			req := httptest.NewRequest(tt.method, tt.path(record), strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.ContentLength = -1
+
+			w := api.serve(testDoctor, req)
+			expectStatus(t, w, http.StatusRequestEntityTooLarge)
+			if count, _ := api.store.Count(testDoctor.context(), RecordFilter{}); count != 1 {
+				t.Errorf("stored records = %d, want 1", count)
+			}
+			if stored, _ := api.stored(testDoctor, record.ID); stored.Title != record.Title {
+				t.Errorf("title = %.20q, want %q", stored.Title, record.Title)
+			}
+		})
+	}
+}
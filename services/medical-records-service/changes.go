@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// tombstoneCollection remembers deleted records so sync clients can remove
+// their local copies.
+const tombstoneCollection = "medical_record_tombstones"
+
+// RecordTombstone marks a deleted record. Its ID is the deleted record's.
+type RecordTombstone struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	PatientID      string             `bson:"patient_id" json:"patient_id"`
+	DeletedAt      time.Time          `bson:"deleted_at" json:"deleted_at"`
+	DeletedBy      string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// writeTombstone stores a tombstone for a deleted record, replacing any
+// from an earlier deletion of a record upserted again under the same ID.
+// Pass the transaction context so it commits with the delete. The
+// tombstone belongs to the organization of ctx.
+func writeTombstone(ctx context.Context, tombstone RecordTombstone) error {
+	tombstone.OrganizationID = organizationFrom(ctx)
+	return recordStore.WriteTombstone(ctx, tombstone)
+}
+
+// RecordChange is one entry in the changes feed: a created or updated
+// record, or a deletion, which carries no record.
+type RecordChange struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Deleted   bool               `bson:"deleted" json:"deleted"`
+	ChangedAt time.Time          `bson:"changed_at" json:"changed_at"`
+	Record    *MedicalRecord     `bson:"record,omitempty" json:"record,omitempty"`
+}
+
+// changesCursor is the position after the last change a client has seen.
+type changesCursor struct {
+	ChangedAt time.Time
+	ID        primitive.ObjectID
+}
+
+// maxObjectID sorts after every other ID, so a cursor at a time with it is
+// past all changes at that time.
+var maxObjectID = primitive.ObjectID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+func (cur changesCursor) encode() string {
+	raw := cur.ChangedAt.UTC().Format(time.RFC3339Nano) + "|" + cur.ID.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChangesCursor(value string) (changesCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return changesCursor{}, fmt.Errorf("invalid cursor")
+	}
+	changedAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return changesCursor{}, fmt.Errorf("invalid cursor")
+	}
+	var cur changesCursor
+	if cur.ChangedAt, err = time.Parse(time.RFC3339Nano, changedAt); err != nil {
+		return changesCursor{}, fmt.Errorf("invalid cursor")
+	}
+	if cur.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return changesCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return cur, nil
+}
+
+// getRecordChanges returns records changed after since, oldest change
+// first, for incremental sync. Deleted records appear as tombstones with
+// deleted set. Pages are linked by next_cursor, which replaces since on
+// the following request; the last page's cursor is kept for the next sync.
+//
+// @Summary Changes since a point in time
+// @Description Either since or cursor is required. Deleted records are reported with deleted set and no record.
+// @Tags Records
+// @Produce json
+// @Param since query string false "Changes after this time (RFC 3339)" format(date-time)
+// @Param cursor query string false "next_cursor from the previous page"
+// @Param limit query int false "Changes per page" minimum(1)
+// @Success 200 {object} object{changes=[]RecordChange,has_more=bool,next_cursor=string}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/changes [get]
+func getRecordChanges(c *gin.Context) {
+	var position changesCursor
+	var cursorValue string
+	switch {
+	case c.Query("cursor") != "":
+		cur, err := decodeChangesCursor(c.Query("cursor"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cursorValue = c.Query("cursor")
+		position = cur
+	case c.Query("since") != "":
+		since, err := time.Parse(time.RFC3339, c.Query("since"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 timestamp"})
+			return
+		}
+		position = changesCursor{ChangedAt: since, ID: maxObjectID}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since or cursor is required"})
+		return
+	}
+
+	limit := defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	// One extra change tells whether another page follows
+	changes, err := recordStore.Changes(ctx, position, limit+1)
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch record changes")
+		respondQueryError(c, err, "Failed to fetch changes")
+		return
+	}
+
+	hasMore := len(changes) > limit
+	if hasMore {
+		changes = changes[:limit]
+	}
+	if len(changes) > 0 {
+		last := changes[len(changes)-1]
+		cursorValue = changesCursor{ChangedAt: last.ChangedAt, ID: last.ID}.encode()
+	}
+
+	response := gin.H{"changes": changes, "has_more": hasMore}
+	if cursorValue != "" {
+		response["next_cursor"] = cursorValue
+	}
+	c.JSON(http.StatusOK, response)
+}
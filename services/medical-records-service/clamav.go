@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamavChunkSize is the size of each INSTREAM chunk sent to clamd.
+const clamavChunkSize = 64 * 1024
+
+// ClamAVScanner streams data to a clamd daemon using the INSTREAM command.
+type ClamAVScanner struct {
+	Address string
+	Timeout time.Duration
+}
+
+// ScanResult is the outcome of a scan. Signature is set when Infected.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// newClamAVScanner returns a scanner for the clamd address, or nil when
+// scanning is not configured.
+func newClamAVScanner(address string, timeout time.Duration) *ClamAVScanner {
+	if address == "" {
+		return nil
+	}
+	return &ClamAVScanner{Address: address, Timeout: timeout}
+}
+
+// Scan streams r to clamd in chunks so large files are never fully buffered.
+// The whole scan is bounded by the scanner timeout.
+func (s *ClamAVScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, fmt.Errorf("send INSTREAM: %w", err)
+	}
+
+	buf := make([]byte, clamavChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return ScanResult{}, fmt.Errorf("send chunk: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanResult{}, fmt.Errorf("send chunk: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+
+	// A zero-length chunk terminates the stream
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return ScanResult{}, fmt.Errorf("terminate stream: %w", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseClamAVReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamAVReply interprets replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamAVReply(reply string) (ScanResult, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd error: %s", reply)
+	}
+}
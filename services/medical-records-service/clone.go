@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cloneRequest is the optional body of a clone request.
+type cloneRequest struct {
+	Consent *Consent `json:"consent"`
+}
+
+// cloneMedicalRecord creates a new record from the clinical content of an
+// existing one, for use as a template. Attachments, links to related
+// records, edit locks, the appointment link and the patient's consent stay
+// with the source record; cloning a confidential record needs consent
+// recorded again in the body.
+//
+// @Summary Clone a record
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param request body object{consent=Consent} false "Consent for the clone of a confidential record"
+// @Success 201 {object} MedicalRecord
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/clone [post]
+func cloneMedicalRecord(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	var req cloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondBindError(c, err)
+		return
+	}
+	if req.Consent != nil {
+		if err := validate.Struct(req.Consent); err != nil {
+			respondValidationError(c, err)
+			return
+		}
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	record, err := findRecord(ctx, objectID)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+			return
+		}
+		logger.WithError(err).Error("Failed to fetch medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch record"})
+		return
+	}
+
+	now := time.Now().UTC()
+	record.ID = primitive.NewObjectID()
+	record.AppointmentID = ""
+	record.Attachments = nil
+	record.RelatedRecordIDs = nil
+	record.LockedBy = ""
+	record.LockExpiresAt = nil
+	record.Consent = req.Consent
+	record.CreatedAt = now
+	record.UpdatedAt = now
+	record.CreatedBy = currentUser(c)
+	record.LastModifiedBy = currentUser(c)
+	markExpiredPrescriptions(record.Prescriptions, now)
+
+	if record.Consent != nil {
+		normalizeConsent(record.Consent, now)
+		record.Consent.RecordedBy = currentUser(c)
+	}
+	if err := checkConsent(record.IsConfidential, record.Consent); err != nil {
+		respondConsentRequired(c)
+		return
+	}
+
+	record.ReferenceNumber, err = nextReferenceNumber(ctx, now)
+	if err != nil {
+		logger.WithError(err).Error("Failed to generate reference number")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clone record"})
+		return
+	}
+
+	if err := recordStore.Create(ctx, &record); err != nil {
+		logger.WithError(err).Error("Failed to clone medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clone record"})
+		return
+	}
+
+	logger.WithField("record_id", record.ID.Hex()).WithField("source_id", objectID.Hex()).Info("Medical record cloned")
+	c.JSON(http.StatusCreated, record)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "fmt"
+
+// checkRecordCompleteness reports recommended but optional fields missing
+// from record. These are soft issues: the record is still accepted unless
+// the client asks for strict validation.
+func checkRecordCompleteness(record *MedicalRecord) []RecordWarning {
+	var warnings []RecordWarning
+	missing := func(field, message string) {
+		warnings = append(warnings, RecordWarning{
+			Type:     "missing_recommended_field",
+			Field:    field,
+			Message:  message,
+			Severity: "low",
+		})
+	}
+
+	if record.Description == "" {
+		missing("description", "Record has no description")
+	}
+	for i, prescription := range record.Prescriptions {
+		if prescription.Duration == "" && prescription.EndDate.IsZero() {
+			missing(fmt.Sprintf("prescriptions[%d].end_date", i), "Prescription has neither a duration nor an end date")
+		}
+	}
+	for i, labResult := range record.LabResults {
+		if labResult.ReferenceRange == "" {
+			missing(fmt.Sprintf("lab_results[%d].reference_range", i), "Lab result has no reference range")
+		}
+		if labResult.Unit == "" {
+			missing(fmt.Sprintf("lab_results[%d].unit", i), "Lab result has no unit")
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// compressionLevel is the gzip and deflate level (COMPRESSION_LEVEL,
+	// -1 to 9).
+	compressionLevel = gzip.DefaultCompression
+
+	// compressionMinBytes is the smallest response that gets compressed
+	// (COMPRESSION_MIN_BYTES). Tiny bodies are not worth the overhead.
+	compressionMinBytes = 1024
+)
+
+// compressionExcludedPaths are never compressed. /metrics is left to
+// Prometheus' own content negotiation.
+var compressionExcludedPaths = map[string]bool{
+	"/metrics": true,
+}
+
+// incompressibleTypes are content types that are already compressed, so
+// encoding them again only costs CPU.
+var incompressibleTypes = map[string]bool{
+	"application/zip": true,
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+}
+
+// compressionEncodings are the supported encodings in order of preference
+// when a client accepts several with the same quality.
+var compressionEncodings = []string{"gzip", "deflate"}
+
+// compressor is the part of gzip.Writer and flate.Writer the middleware
+// uses.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newCompressor returns a writer producing encoding into w.
+func newCompressor(encoding string, w io.Writer) (compressor, error) {
+	if encoding == "deflate" {
+		return flate.NewWriter(w, compressionLevel)
+	}
+	return gzip.NewWriterLevel(w, compressionLevel)
+}
+
+// compressionMiddleware gzips or deflates responses of at least
+// compressionMinBytes, depending on the client's Accept-Encoding.
+func compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if compressionExcludedPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		// Whether the body is compressed depends on Accept-Encoding either way
+		c.Header("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+
+		c.Next()
+
+		w.finish()
+	}
+}
+
+// negotiateEncoding picks the supported encoding with the highest non-zero
+// quality in an Accept-Encoding header, or "" if none is acceptable. "*"
+// stands for any encoding not listed explicitly.
+func negotiateEncoding(header string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+		if encoding == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		qualities[encoding] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range compressionEncodings {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = qualities["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body reaches the compression threshold, then either switches to the
+// negotiated encoding or writes the buffered bytes through unchanged.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	buf      bytes.Buffer
+	cw       compressor
+	raw      bool
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	switch {
+	case w.cw != nil:
+		return w.cw.Write(data)
+	case w.raw:
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buf.Write(data)
+	if w.buf.Len() >= compressionMinBytes {
+		if err := w.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush commits to compression so streamed responses are not held back by
+// the buffer.
+func (w *compressWriter) Flush() {
+	if w.cw == nil && !w.raw {
+		if err := w.startCompression(); err != nil {
+			return
+		}
+	}
+	if w.cw != nil {
+		w.cw.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) startCompression() error {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		// Already encoded by the handler; pass it through untouched
+		return w.writeRaw()
+	}
+	if contentType, _, _ := strings.Cut(header.Get("Content-Type"), ";"); incompressibleTypes[contentType] {
+		return w.writeRaw()
+	}
+
+	cw, err := newCompressor(w.encoding, w.ResponseWriter)
+	if err != nil {
+		return w.writeRaw()
+	}
+	// Any length the handler set describes the uncompressed body
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	w.cw = cw
+
+	_, err = cw.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) writeRaw() error {
+	w.raw = true
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish flushes whatever is still buffered once the handler is done.
+func (w *compressWriter) finish() {
+	if w.cw != nil {
+		w.cw.Close()
+		return
+	}
+	if w.buf.Len() > 0 {
+		w.writeRaw()
+	}
+}
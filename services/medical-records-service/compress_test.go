@@ -0,0 +1,57 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "")
+
+	tests := []struct {
+		name           string
+		path           string
+		records        int
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "large list", path: "/api/v1/medical-records?limit=50", records: 20, acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "client does not accept it", path: "/api/v1/medical-records?limit=50", records: 20},
+		{name: "below the threshold", path: "/api/v1/medical-records", acceptEncoding: "gzip"},
+		// promhttp only gzips, so deflate shows whether the middleware ran
+		{name: "metrics", path: "/metrics", acceptEncoding: "deflate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			for i := 0; i < tt.records; i++ {
+				api.seed(testDoctor, MedicalRecord{Description: "Routine follow-up visit with no new complaints"})
+			}
+
+			w := api.doWithHeaders(testDoctor, http.MethodGet, tt.path, nil, map[string]string{"Accept-Encoding": tt.acceptEncoding})
+			expectStatus(t, w, http.StatusOK)
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantEncoding != "gzip" {
+				return
+			}
+
+			reader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Records []MedicalRecord `json:"records"`
+			}
+			if err := json.NewDecoder(reader).Decode(&got); err != nil {
+				t.Fatalf("decode compressed body: %v", err)
+			}
+			if len(got.Records) != tt.records {
+				t.Errorf("records = %d, want %d", len(got.Records), tt.records)
+			}
+		})
+	}
+}
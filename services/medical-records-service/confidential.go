@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type confidentialRequest struct {
+	// A pointer so an explicit false is told apart from a missing field
+	IsConfidential *bool `json:"is_confidential" binding:"required"`
+}
+
+// setRecordConfidential changes only a record's is_confidential flag,
+// saving a revision and an audit entry in the same transaction.
+//
+// @Summary Mark a record confidential or not
+// @Description Requires the doctor or admin role.
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param request body confidentialRequest true "The new flag"
+// @Success 200 {object} object{id=string,is_confidential=bool,updated_at=string,last_modified_by=string}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 403 {object} apiError "The caller's role is not allowed"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/confidential [patch]
+func setRecordConfidential(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	var req confidentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	var updated MedicalRecord
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		var previous bool
+		var err error
+		updated, err = applyRecordUpdate(ctx, objectID, currentUser(c), func(record *MedicalRecord) error {
+			if err := checkConsent(*req.IsConfidential, record.Consent); err != nil {
+				return err
+			}
+			previous = record.IsConfidential
+			record.IsConfidential = *req.IsConfidential
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		entry := newAuditEntry(c, "record_confidentiality_change")
+		entry.RecordID = objectID.Hex()
+		entry.PatientID = updated.PatientID
+		entry.Details = map[string]interface{}{
+			"previous": previous,
+			"current":  updated.IsConfidential,
+		}
+		return writeAudit(ctx, entry)
+	})
+	if errors.Is(err, errConsentRequired) {
+		respondConsentRequired(c)
+		return
+	}
+	if err != nil {
+		respondRecordUpdateError(c, err)
+		return
+	}
+
+	logger.WithField("record_id", objectID.Hex()).Info("Medical record confidentiality updated")
+	c.JSON(http.StatusOK, gin.H{
+		"id":               updated.ID.Hex(),
+		"is_confidential":  updated.IsConfidential,
+		"updated_at":       updated.UpdatedAt,
+		"last_modified_by": updated.LastModifiedBy,
+	})
+}
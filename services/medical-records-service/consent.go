@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Consent is the patient's documented consent decision for a record.
+type Consent struct {
+	ConsentGiven bool      `bson:"consent_given" json:"consent_given"`
+	ConsentType  string    `bson:"consent_type" json:"consent_type" validate:"required"`
+	ConsentDate  time.Time `bson:"consent_date" json:"consent_date"`
+	RecordedBy   string    `bson:"recorded_by,omitempty" json:"recorded_by,omitempty"`
+}
+
+// errConsentRequired is returned when a record would be confidential
+// without a consent decision on file.
+var errConsentRequired = errors.New("confidential records require recorded patient consent")
+
+// consentRecorded reports whether a consent decision, given or refused,
+// has been documented.
+func consentRecorded(consent *Consent) bool {
+	return consent != nil && consent.ConsentType != "" && !consent.ConsentDate.IsZero()
+}
+
+// checkConsent enforces that confidential records have consent recorded.
+func checkConsent(confidential bool, consent *Consent) error {
+	if confidential && !consentRecorded(consent) {
+		return errConsentRequired
+	}
+	return nil
+}
+
+// respondConsentRequired writes the 422 for errConsentRequired.
+func respondConsentRequired(c *gin.Context) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errConsentRequired.Error(), "field": "consent"})
+}
+
+// normalizeConsent defaults the consent date to now and stores it in UTC.
+func normalizeConsent(consent *Consent, now time.Time) {
+	if consent == nil {
+		return
+	}
+	if consent.ConsentDate.IsZero() {
+		consent.ConsentDate = now
+	}
+	consent.ConsentDate = consent.ConsentDate.UTC()
+}
+
+// setRecordConsent replaces a record's consent without touching its
+// clinical data, saving a revision and an audit entry in the same
+// transaction. Consent may be withdrawn by sending consent_given false;
+// the decision stays on file.
+//
+// @Summary Record the patient's consent decision
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param consent body Consent true "The consent decision"
+// @Success 200 {object} object{id=string,consent=Consent,updated_at=string,last_modified_by=string}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/consent [put]
+func setRecordConsent(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	var consent Consent
+	if err := c.ShouldBindJSON(&consent); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if err := validate.Struct(&consent); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	now := time.Now().UTC()
+	normalizeConsent(&consent, now)
+	consent.RecordedBy = currentUser(c)
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	var updated MedicalRecord
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		updated, err = applyRecordUpdate(ctx, objectID, currentUser(c), func(record *MedicalRecord) error {
+			record.Consent = &consent
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		entry := newAuditEntry(c, "record_consent_change")
+		entry.RecordID = objectID.Hex()
+		entry.PatientID = updated.PatientID
+		entry.Details = map[string]interface{}{
+			"consent_given": consent.ConsentGiven,
+			"consent_type":  consent.ConsentType,
+		}
+		return writeAudit(ctx, entry)
+	})
+	if err != nil {
+		respondRecordUpdateError(c, err)
+		return
+	}
+
+	logger.WithField("record_id", objectID.Hex()).Info("Medical record consent updated")
+	c.JSON(http.StatusOK, gin.H{
+		"id":               updated.ID.Hex(),
+		"consent":          updated.Consent,
+		"updated_at":       updated.UpdatedAt,
+		"last_modified_by": updated.LastModifiedBy,
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// analyticsReadPref is the read preference for read-heavy endpoints:
+// listings and counts, patient summary, prescription search and record
+// metrics. It comes from MONGO_READ_PREFERENCE and defaults to primary.
+//
+// Routing these reads to secondaries offloads the primary but they may lag
+// behind recent writes by the replication delay. Single-record reads and the
+// read-back after a write always stay on the primary so clients see their
+// own changes.
+var analyticsReadPref = readpref.Primary()
+
+// parseReadPreference accepts the mode names primary, primaryPreferred,
+// secondary, secondaryPreferred and nearest.
+func parseReadPreference(value string) (*readpref.ReadPref, error) {
+	mode, err := readpref.ModeFromString(value)
+	if err != nil {
+		return nil, err
+	}
+	return readpref.New(mode)
+}
+
+// parseWriteConcern accepts "majority", a node count such as "1", or a
+// custom tag set name.
+func parseWriteConcern(value string) (*writeconcern.WriteConcern, error) {
+	if value == "majority" {
+		return writeconcern.Majority(), nil
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 0 {
+			return nil, fmt.Errorf("invalid write concern %q", value)
+		}
+		return &writeconcern.WriteConcern{W: n}, nil
+	}
+	return writeconcern.Custom(value), nil
+}
+
+// applyConsistencyOptions reads MONGO_READ_PREFERENCE and
+// MONGO_WRITE_CONCERN. The write concern applies to every write; majority
+// makes acknowledged writes survive a primary failover at the cost of
+// higher write latency. Unset values keep the driver defaults.
+func applyConsistencyOptions(clientOptions *options.ClientOptions) {
+	if value := os.Getenv("MONGO_READ_PREFERENCE"); value != "" {
+		pref, err := parseReadPreference(value)
+		if err != nil {
+			logger.WithError(err).Warn("Invalid MONGO_READ_PREFERENCE, using primary")
+		} else {
+			analyticsReadPref = pref
+		}
+	}
+
+	writeConcern := "default"
+	if value := os.Getenv("MONGO_WRITE_CONCERN"); value != "" {
+		wc, err := parseWriteConcern(value)
+		if err != nil {
+			logger.WithError(err).Warn("Invalid MONGO_WRITE_CONCERN, using driver default")
+		} else {
+			clientOptions.SetWriteConcern(wc)
+			writeConcern = value
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"read_preference": analyticsReadPref.Mode().String(),
+		"write_concern":   writeConcern,
+	}).Info("MongoDB consistency settings")
+}
+
+// analyticsCollection returns the records collection using the analytics
+// read preference, scoped by organization.
+func analyticsCollection() scopedCollection {
+	return scopedCollection{collection: unscopedAnalyticsCollection(), field: "organization_id", live: true}
+}
+
+// unscopedAnalyticsCollection is analyticsCollection across all
+// organizations, for service-wide metrics only.
+func unscopedAnalyticsCollection() *mongo.Collection {
+	return db.Collection(recordsCollectionName, options.Collection().SetReadPreference(analyticsReadPref))
+}
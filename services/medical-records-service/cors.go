@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// corsAllowedOrigins are the browser origins allowed to call the API
+	// (CORS_ALLOWED_ORIGINS, comma separated). "*" allows any origin. When
+	// empty no CORS headers are sent.
+	corsAllowedOrigins map[string]bool
+
+	// corsAllowCredentials lets browsers send cookies and auth headers
+	// cross-origin (CORS_ALLOW_CREDENTIALS).
+	corsAllowCredentials bool
+
+	// corsMaxAge is how long browsers may cache a preflight response
+	// (CORS_MAX_AGE).
+	corsMaxAge = 10 * time.Minute
+)
+
+// corsAllowedHeaders are the request headers browsers may send.
+const corsAllowedHeaders = "Authorization, Content-Type, Idempotency-Key, If-Modified-Since, If-None-Match, X-Dry-Run, X-Confirm-Erasure, X-Organization-ID"
+
+// corsAllowedMethods are the methods browsers may use.
+const corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// loadCORSConfig reads the CORS settings from the environment.
+func loadCORSConfig() {
+	corsAllowedOrigins = make(map[string]bool)
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			corsAllowedOrigins[origin] = true
+		}
+	}
+	corsAllowCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "true"
+	corsMaxAge = getEnvDuration("CORS_MAX_AGE", 10*time.Minute)
+
+	if corsAllowCredentials && corsAllowedOrigins["*"] {
+		logger.Warn("CORS_ALLOW_CREDENTIALS with a wildcard origin reflects any origin; list origins explicitly instead")
+	}
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers
+// preflight requests. The origin is checked against the allowlist before it
+// is reflected; with credentials allowed it is always reflected, since
+// browsers reject a wildcard for credentialed requests.
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || len(corsAllowedOrigins) == 0 {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !corsAllowedOrigins[origin] && !corsAllowedOrigins["*"] {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if corsAllowedOrigins["*"] && !corsAllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if corsAllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		c.Header("Access-Control-Expose-Headers", "ETag")
+
+		if preflight {
+			c.Header("Access-Control-Allow-Methods", corsAllowedMethods)
+			c.Header("Access-Control-Allow-Headers", corsAllowedHeaders)
+			if corsMaxAge > 0 {
+				c.Header("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// duplicateDiagnosisMode decides what happens when a record would hold the
+// same active diagnosis code twice (DUPLICATE_DIAGNOSIS_MODE): "warn" keeps
+// the change and returns a warning, "reject" refuses it with a 400.
+var duplicateDiagnosisMode = "warn"
+
+// DuplicateDiagnosisError lists diagnosis codes that are active more than
+// once in a record.
+type DuplicateDiagnosisError struct {
+	Codes []string
+}
+
+func (e *DuplicateDiagnosisError) Error() string {
+	return fmt.Sprintf("duplicate active diagnosis codes: %s", strings.Join(e.Codes, ", "))
+}
+
+// duplicateDiagnosisCodes returns the codes of diagnoses that appear more
+// than once among those not resolved. Codes are compared case-insensitively.
+func duplicateDiagnosisCodes(diagnoses []Diagnosis) []string {
+	seen := make(map[string]int)
+	var duplicates []string
+	for _, diagnosis := range diagnoses {
+		if diagnosis.Status == "resolved" {
+			continue
+		}
+		code := strings.ToUpper(strings.TrimSpace(diagnosis.Code))
+		seen[code]++
+		if seen[code] == 2 {
+			duplicates = append(duplicates, code)
+		}
+	}
+	return duplicates
+}
+
+// checkDuplicateDiagnoses applies duplicateDiagnosisMode to duplicate
+// codes. It returns a *DuplicateDiagnosisError in reject mode and warnings
+// otherwise.
+func checkDuplicateDiagnoses(codes []string) ([]RecordWarning, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	if duplicateDiagnosisMode == "reject" {
+		return nil, &DuplicateDiagnosisError{Codes: codes}
+	}
+
+	warnings := make([]RecordWarning, 0, len(codes))
+	for _, code := range codes {
+		warnings = append(warnings, RecordWarning{
+			Type:    "duplicate_diagnosis",
+			Field:   "diagnosis",
+			Message: fmt.Sprintf("diagnosis %s is already active in this record", code),
+		})
+	}
+	return warnings, nil
+}
+
+// respondDuplicateDiagnosis writes the 400 for a *DuplicateDiagnosisError.
+func respondDuplicateDiagnosis(c *gin.Context, err *DuplicateDiagnosisError) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": err.Error(),
+		"field": "diagnosis",
+		"codes": err.Codes,
+	})
+}
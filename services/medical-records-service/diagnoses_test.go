@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAddDiagnosisDetectsDuplicates(t *testing.T) {
+	hypertension := Diagnosis{Code: "I10", Description: "Hypertension", Severity: "moderate", Status: "active"}
+	resolved := hypertension
+	resolved.Status = "resolved"
+
+	tests := []struct {
+		name         string
+		mode         string
+		existing     []Diagnosis
+		code         string
+		wantStatus   int
+		wantWarnings int
+		wantStored   int
+	}{
+		{name: "warns on an active duplicate", mode: "warn", existing: []Diagnosis{hypertension}, code: "i10", wantStatus: http.StatusOK, wantWarnings: 1, wantStored: 2},
+		{name: "rejects an active duplicate", mode: "reject", existing: []Diagnosis{hypertension}, code: "I10", wantStatus: http.StatusBadRequest, wantStored: 1},
+		{name: "resolved code may recur", mode: "reject", existing: []Diagnosis{resolved}, code: "I10", wantStatus: http.StatusOK, wantStored: 2},
+		{name: "new code", mode: "reject", existing: []Diagnosis{hypertension}, code: "J45", wantStatus: http.StatusOK, wantStored: 2},
+		{
+			// Duplicates already stored are left to the next full update
+			name:       "only the appended code counts",
+			mode:       "reject",
+			existing:   []Diagnosis{hypertension, hypertension},
+			code:       "J45",
+			wantStatus: http.StatusOK,
+			wantStored: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := duplicateDiagnosisMode
+			duplicateDiagnosisMode = tt.mode
+			t.Cleanup(func() { duplicateDiagnosisMode = previous })
+
+			api := newTestAPI(t)
+			record := api.seed(testDoctor, MedicalRecord{Diagnosis: tt.existing})
+
+			w := api.do(testDoctor, http.MethodPost, recordURL(record.ID)+"/diagnoses", map[string]interface{}{
+				"code":        tt.code,
+				"description": "Added diagnosis",
+				"severity":    "mild",
+			})
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus == http.StatusBadRequest {
+				if got := decodeBody[struct {
+					Codes []string `json:"codes"`
+				}](t, w); len(got.Codes) != 1 || got.Codes[0] != "I10" {
+					t.Errorf("codes = %v, want [I10]", got.Codes)
+				}
+			} else if got := decodeBody[createRecordResponse](t, w); len(got.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %+v, want %d", got.Warnings, tt.wantWarnings)
+			}
+
+			stored, err := api.stored(testDoctor, record.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored.Diagnosis) != tt.wantStored {
+				t.Errorf("stored diagnoses = %d, want %d", len(stored.Diagnosis), tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestAddDiagnosisRejectsConcurrentDuplicate(t *testing.T) {
+	previous := duplicateDiagnosisMode
+	duplicateDiagnosisMode = "reject"
+	t.Cleanup(func() { duplicateDiagnosisMode = previous })
+
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{})
+	diagnosis := map[string]interface{}{"code": "I10", "description": "Hypertension", "severity": "moderate"}
+	// The same code is appended after this request read the record
+	recordStore = newInterleavedStore(api.store, func() {
+		expectStatus(t, api.do(testOtherDoctor, http.MethodPost, recordURL(record.ID)+"/diagnoses", diagnosis), http.StatusOK)
+	})
+
+	w := api.do(testDoctor, http.MethodPost, recordURL(record.ID)+"/diagnoses", diagnosis)
+	expectStatus(t, w, http.StatusBadRequest)
+	stored, err := api.stored(testDoctor, record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Diagnosis) != 1 {
+		t.Errorf("stored diagnoses = %d, want 1", len(stored.Diagnosis))
+	}
+}
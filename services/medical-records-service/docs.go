@@ -0,0 +1,105 @@
+package main
+
+// The API description is generated by swag from the annotations on the
+// handlers; run go generate after changing a route or its annotations.
+//
+//go:generate go run github.com/swaggo/swag/cmd/swag@v1.16.6 init --generalInfo docs.go --outputTypes json
+
+// @title Medical Records Service
+// @version 1.0.0
+// @description Stores patient medical records for a clinic. Every /api request is scoped to the
+// @description organization in the caller's token; in development, where tokens may lack it, the
+// @description X-Organization-ID header is used instead. /api is an alias of /api/v1.
+// @BasePath /
+//
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description A JWT sent as "Bearer <token>".
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec is the OpenAPI 2.0 (Swagger) description of the API,
+// generated into docs/swagger.json.
+//
+//go:embed docs/swagger.json
+var openAPISpec []byte
+
+// recordPage documents a page of records from a listing or search.
+type recordPage struct {
+	Records      []MedicalRecord `json:"records"`
+	Total        int64           `json:"total"`
+	Page         int             `json:"page"`
+	Limit        int             `json:"limit"`
+	LimitClamped bool            `json:"limit_clamped"`
+	TotalPages   int             `json:"total_pages"`
+	HasNext      bool            `json:"has_next"`
+	HasPrevious  bool            `json:"has_previous"`
+	Next         string          `json:"next,omitempty"`
+	Prev         string          `json:"prev,omitempty"`
+	Warnings     []RecordWarning `json:"warnings,omitempty"`
+}
+
+// apiError documents an error response. Only error is always set; field
+// names the offending input and validation failures add details and
+// fields.
+type apiError struct {
+	Error   string            `json:"error"`
+	Field   string            `json:"field,omitempty"`
+	Details []fieldError      `json:"details,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// apiDocsEnabled serves the Swagger UI at /docs (API_DOCS_ENABLED). The
+// spec at /openapi.json is always served for client generation.
+var apiDocsEnabled = true
+
+// openAPIHandler serves the spec with info.version set to the running
+// build.
+func openAPIHandler() gin.HandlerFunc {
+	spec := openAPISpec
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err == nil {
+		if info, ok := doc["info"].(map[string]interface{}); ok {
+			info["version"] = version
+		}
+		if data, err := json.Marshal(doc); err == nil {
+			spec = data
+		}
+	} else {
+		logger.WithError(err).Error("Failed to parse embedded OpenAPI spec")
+	}
+
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	}
+}
+
+// swaggerUIPage renders /openapi.json with Swagger UI loaded from a CDN.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Medical Records Service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5.11.0/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5.11.0/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// docsHandler serves the Swagger UI.
+func docsHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxDocumentBytes is MongoDB's limit on the encoded size of a document.
+const maxDocumentBytes = 16 * 1024 * 1024
+
+// documentSizeWarnBytes is the encoded size from which records are
+// flagged as approaching maxDocumentBytes.
+const documentSizeWarnBytes = maxDocumentBytes * 8 / 10
+
+// DocumentSizeError reports a record too large to store.
+type DocumentSizeError struct {
+	Size int
+}
+
+func (e *DocumentSizeError) Error() string {
+	return fmt.Sprintf("record is %d bytes, more than the %d bytes a record may hold", e.Size, maxDocumentBytes)
+}
+
+// checkRecordSize encodes record as it would be stored. It returns a
+// *DocumentSizeError when the record cannot be written and a warning when
+// it is close to the limit.
+func checkRecordSize(record MedicalRecord) ([]RecordWarning, error) {
+	data, err := bson.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	size := len(data)
+	if size > maxDocumentBytes {
+		return nil, &DocumentSizeError{Size: size}
+	}
+	if size < documentSizeWarnBytes {
+		return nil, nil
+	}
+	return []RecordWarning{{
+		Type:     "document_size",
+		Message:  fmt.Sprintf("record is %d bytes, %d%% of the maximum record size", size, size*100/maxDocumentBytes),
+		Severity: "warning",
+	}}, nil
+}
+
+// respondDocumentTooLarge writes the 400 for a *DocumentSizeError.
+func respondDocumentTooLarge(c *gin.Context, err *DocumentSizeError) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":     err.Error(),
+		"size":      err.Size,
+		"max_bytes": maxDocumentBytes,
+	})
+}
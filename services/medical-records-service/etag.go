@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordETag returns a strong ETag for record. It hashes the encoded record
+// rather than only updated_at, which is stored to the millisecond, so two
+// writes within the same millisecond still get different tags.
+func recordETag(record MedicalRecord) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match header matches etag. Weak
+// validators compare equal to their strong form, as RFC 9110 requires for
+// If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince reports whether an If-Modified-Since header is at or
+// after modified. HTTP dates have one-second precision, so modified is
+// truncated before comparing. Unparseable dates never match.
+func notModifiedSince(header string, modified time.Time) bool {
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// respondWithETag writes record with its ETag and Last-Modified, or 304 Not
+// Modified when the client's cached copy is current. As RFC 9110 requires,
+// If-Modified-Since is only consulted when If-None-Match is absent.
+func respondWithETag(c *gin.Context, record MedicalRecord) {
+	etag, err := recordETag(record)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to compute record ETag")
+		c.JSON(http.StatusOK, record)
+		return
+	}
+
+	c.Header("ETag", etag)
+	if !record.UpdatedAt.IsZero() {
+		c.Header("Last-Modified", record.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+	// Records hold PHI, so only the client may cache them and it must
+	// revalidate every time
+	c.Header("Cache-Control", "private, no-cache")
+
+	notModified := false
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		notModified = etagMatches(match, etag)
+	} else if since := c.GetHeader("If-Modified-Since"); since != "" && !record.UpdatedAt.IsZero() {
+		notModified = notModifiedSince(since, record.UpdatedAt)
+	}
+	if notModified {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
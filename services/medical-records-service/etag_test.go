@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetMedicalRecordETag(t *testing.T) {
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{})
+
+	w := api.do(testDoctor, http.MethodGet, recordURL(record.ID), nil)
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("response has no ETag")
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{name: "current", ifNoneMatch: etag, wantStatus: http.StatusNotModified},
+		{name: "weak form", ifNoneMatch: "W/" + etag, wantStatus: http.StatusNotModified},
+		{name: "one of several", ifNoneMatch: `"stale", ` + etag, wantStatus: http.StatusNotModified},
+		{name: "stale", ifNoneMatch: `"stale"`, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.doWithHeaders(testDoctor, http.MethodGet, recordURL(record.ID), nil, map[string]string{"If-None-Match": tt.ifNoneMatch})
+			expectStatus(t, w, tt.wantStatus)
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %s, want %s", got, etag)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 has a body: %s", w.Body.String())
+			}
+		})
+	}
+
+	t.Run("changed since", func(t *testing.T) {
+		expectStatus(t, api.do(testDoctor, http.MethodPut, recordURL(record.ID), recordUpdate("Follow-up")), http.StatusOK)
+
+		w := api.doWithHeaders(testDoctor, http.MethodGet, recordURL(record.ID), nil, map[string]string{"If-None-Match": etag})
+		expectStatus(t, w, http.StatusOK)
+		if w.Header().Get("ETag") == etag {
+			t.Error("ETag unchanged after an update")
+		}
+		if got := decodeBody[MedicalRecord](t, w); got.Title != "Follow-up" {
+			t.Errorf("title = %s, want Follow-up", got.Title)
+		}
+	})
+}
+
+func TestGetMedicalRecordLastModified(t *testing.T) {
+	api := newTestAPI(t)
+	updated := time.Date(2024, 3, 15, 12, 0, 0, 500_000_000, time.UTC)
+	record := api.seed(testDoctor, MedicalRecord{CreatedAt: updated})
+	lastModified := "Fri, 15 Mar 2024 12:00:00 GMT"
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{name: "unconditional", wantStatus: http.StatusOK},
+		{name: "same second", headers: map[string]string{"If-Modified-Since": lastModified}, wantStatus: http.StatusNotModified},
+		{name: "later", headers: map[string]string{"If-Modified-Since": "Sat, 16 Mar 2024 00:00:00 GMT"}, wantStatus: http.StatusNotModified},
+		{name: "earlier", headers: map[string]string{"If-Modified-Since": "Fri, 15 Mar 2024 11:59:59 GMT"}, wantStatus: http.StatusOK},
+		{name: "unparseable", headers: map[string]string{"If-Modified-Since": "yesterday"}, wantStatus: http.StatusOK},
+		{
+			// If-None-Match takes precedence when both are sent
+			name:       "stale ETag",
+			headers:    map[string]string{"If-Modified-Since": lastModified, "If-None-Match": `"stale"`},
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.doWithHeaders(testDoctor, http.MethodGet, recordURL(record.ID), nil, tt.headers)
+			expectStatus(t, w, tt.wantStatus)
+			if got := w.Header().Get("Last-Modified"); got != lastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, lastModified)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 has a body: %s", w.Body.String())
+			}
+		})
+	}
+}
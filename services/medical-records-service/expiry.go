@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var prescriptionsExpired = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "medical_records_prescriptions_expired_total",
+		Help: "Number of prescriptions marked expired by the expiry sweep",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(prescriptionsExpired)
+}
+
+// prescriptionExpired reports whether p's end date has passed.
+// Prescriptions without an end date never expire.
+func prescriptionExpired(p Prescription, now time.Time) bool {
+	return !p.EndDate.IsZero() && p.EndDate.Before(now)
+}
+
+// markExpiredPrescriptions sets Expired on each prescription so the flag
+// is correct from the moment a record is written.
+func markExpiredPrescriptions(prescriptions []Prescription, now time.Time) {
+	for i := range prescriptions {
+		prescriptions[i].Expired = prescriptionExpired(prescriptions[i], now)
+	}
+}
+
+// expiredPrescriptionMatch matches prescriptions that have ended but are not
+// yet flagged. The zero time is excluded because it means "no end date".
+func expiredPrescriptionMatch(prefix string, now time.Time) bson.M {
+	return bson.M{
+		prefix + "end_date": bson.M{"$gt": time.Time{}, "$lt": now},
+		prefix + "expired":  bson.M{"$ne": true},
+	}
+}
+
+// expirePrescriptions flags every prescription whose end date has passed and
+// returns how many were flagged. The flag is derived data, so no history
+// revision is written for it, but updated_at is moved on so If-Modified-Since
+// and the changes feed see the change.
+func expirePrescriptions(ctx context.Context) (int64, error) {
+	now := time.Now().UTC()
+	collection := db.Collection(recordsCollectionName)
+
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"prescriptions": bson.M{"$elemMatch": expiredPrescriptionMatch("", now)}}},
+		{"$unwind": "$prescriptions"},
+		{"$match": expiredPrescriptionMatch("prescriptions.", now)},
+		{"$count": "count"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var counts []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return 0, err
+	}
+	if len(counts) == 0 {
+		return 0, nil
+	}
+
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"prescriptions": bson.M{"$elemMatch": expiredPrescriptionMatch("", now)}},
+		bson.M{"$set": bson.M{"prescriptions.$[p].expired": true, "updated_at": now}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{expiredPrescriptionMatch("p.", now)},
+		}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return counts[0].Count, nil
+}
+
+// startPrescriptionExpirySweep runs expirePrescriptions every interval in
+// the background until shutdown. A non-positive interval disables it.
+func startPrescriptionExpirySweep(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	goBackground(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(backgroundCtx, jobTimeout)
+			expired, err := expirePrescriptions(ctx)
+			cancel()
+			if err != nil {
+				if backgroundCtx.Err() == nil {
+					logger.WithError(err).Warn("Failed to sweep expired prescriptions")
+				}
+			} else {
+				prescriptionsExpired.Add(float64(expired))
+				if expired > 0 {
+					logger.WithField("expired", expired).Info("Marked prescriptions as expired")
+				}
+			}
+
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportManifest lists the contents of a patient export archive.
+type exportManifest struct {
+	PatientID   string                   `json:"patient_id"`
+	GeneratedAt time.Time                `json:"generated_at"`
+	Records     []exportManifestRecord   `json:"records"`
+	Attachments []exportManifestAttached `json:"attachments"`
+}
+
+type exportManifestRecord struct {
+	ID              string `json:"id"`
+	ReferenceNumber string `json:"reference_number,omitempty"`
+	Path            string `json:"path"`
+}
+
+type exportManifestAttached struct {
+	RecordID string `json:"record_id"`
+	FileName string `json:"file_name"`
+	Path     string `json:"path,omitempty"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"`
+	// Missing is set when the stored file could not be read or verified
+	Missing bool `json:"missing,omitempty"`
+}
+
+// exportPatientData streams a zip of all of a patient's records as JSON and
+// their attachment files, for data portability and subject access
+// requests. Records are read one at a time from the store and files are
+// copied straight into the response, so memory use does not grow with the
+// export. manifest.json, written last, lists everything in the archive.
+//
+// @Summary Export a patient's records and attachments
+// @Description Requires the doctor or admin role.
+// @Tags Patients
+// @Produce application/zip
+// @Param patient_id path string true "Patient ID"
+// @Success 200 {file} file "Zip archive"
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 403 {object} apiError "The caller's role is not allowed"
+// @Failure 404 {object} apiError "The patient has no records"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/patients/{patient_id}/export.zip [get]
+func exportPatientData(c *gin.Context) {
+	patientID := normalizeID(c.Param("patient_id"))
+
+	// Exports of large imaging files can take a while
+	ctx, cancel := exportContext(c)
+	defer cancel()
+
+	filter := RecordFilter{PatientID: patientID}
+	count, err := recordStore.Count(ctx, filter)
+	if err != nil {
+		logger.WithError(err).Error("Failed to count patient records")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export patient data"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No records found for patient"})
+		return
+	}
+
+	entry := newAuditEntry(c, "patient_export")
+	entry.PatientID = patientID
+	entry.Details = map[string]interface{}{"records": count}
+	if err := writeAudit(ctx, entry); err != nil {
+		logger.WithError(err).Error("Failed to write export audit entry")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export patient data"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": "patient-" + path.Base(patientID) + "-export.zip",
+	}))
+	c.Status(http.StatusOK)
+
+	// From here on the status is sent, so failures can only be logged and
+	// the archive cut short
+	query := RecordQuery{
+		RecordFilter: filter,
+		Sort:         []SortField{{Field: "created_at"}, {Field: "_id"}},
+	}
+	if err := writePatientExport(ctx, c.Writer, patientID, query); err != nil {
+		logger.WithError(err).WithField("patient_id", redactValue(patientID)).Error("Patient export aborted")
+		c.Abort()
+		return
+	}
+
+	logger.WithField("records", count).Info("Patient data exported")
+}
+
+// writePatientExport writes the export archive of the records matching
+// query to w.
+func writePatientExport(ctx context.Context, w io.Writer, patientID string, query RecordQuery) error {
+	archive := zip.NewWriter(w)
+	manifest := exportManifest{
+		PatientID:   patientID,
+		GeneratedAt: time.Now().UTC(),
+		Records:     []exportManifestRecord{},
+		Attachments: []exportManifestAttached{},
+	}
+
+	err := recordStore.Each(ctx, query, func(record MedicalRecord) error {
+		recordPath := "records/" + record.ID.Hex() + ".json"
+		if err := writeZipJSON(archive, recordPath, record); err != nil {
+			return err
+		}
+		manifest.Records = append(manifest.Records, exportManifestRecord{
+			ID:              record.ID.Hex(),
+			ReferenceNumber: record.ReferenceNumber,
+			Path:            recordPath,
+		})
+
+		for _, attachment := range record.Attachments {
+			entry, err := writeZipAttachment(archive, record.ID.Hex(), attachment)
+			if err != nil {
+				return err
+			}
+			manifest.Attachments = append(manifest.Attachments, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeZipJSON(archive, "manifest.json", manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeZipJSON adds v to archive as an indented JSON file.
+func writeZipJSON(archive *zip.Writer, name string, v interface{}) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeZipAttachment copies an attachment file into archive. Files are
+// stored uncompressed since images and PDFs are already compressed. A file
+// that cannot be opened, lies outside attachmentDir or does not match its
+// checksum is left out and reported as missing in the manifest rather than
+// failing the whole export.
+func writeZipAttachment(archive *zip.Writer, recordID string, attachment Attachment) (exportManifestAttached, error) {
+	entry := exportManifestAttached{
+		RecordID: recordID,
+		FileName: attachment.FileName,
+		Size:     attachment.FileSize,
+		Checksum: attachment.Checksum,
+	}
+
+	filePath, err := verifiedAttachmentPath(attachment)
+	if err != nil {
+		logger.WithError(err).WithField("path", attachment.StoragePath).Warn("Attachment skipped in export")
+		entry.Missing = true
+		return entry, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		logger.WithError(err).WithField("path", attachment.StoragePath).Warn("Attachment missing from export")
+		entry.Missing = true
+		return entry, nil
+	}
+	defer file.Close()
+
+	entry.Path = "attachments/" + recordID + "/" + path.Base(attachment.FileName)
+	f, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     entry.Path,
+		Method:   zip.Store,
+		Modified: attachment.UploadedAt,
+	})
+	if err != nil {
+		return entry, err
+	}
+	if _, err := io.Copy(f, file); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
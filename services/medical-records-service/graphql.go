@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// graphqlSchema exposes medical records over GraphQL so clients can select
+// exactly the nested fields they need. It is built once at startup.
+var graphqlSchema graphql.Schema
+
+type graphqlRequest struct {
+	Query         string                 `json:"query" form:"query"`
+	OperationName string                 `json:"operationName" form:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// field builds a GraphQL field whose value is read from the source struct
+// with get.
+func field[T any](t graphql.Output, get func(T) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			source, ok := p.Source.(T)
+			if !ok {
+				return nil, nil
+			}
+			return get(source), nil
+		},
+	}
+}
+
+// optionalFloat resolves a nil *float64 as null rather than a typed nil.
+func optionalFloat(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func buildGraphQLSchema() (graphql.Schema, error) {
+	diagnosisType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Diagnosis",
+		Fields: graphql.Fields{
+			"code":          field(graphql.String, func(d Diagnosis) interface{} { return d.Code }),
+			"description":   field(graphql.String, func(d Diagnosis) interface{} { return d.Description }),
+			"severity":      field(graphql.String, func(d Diagnosis) interface{} { return d.Severity }),
+			"status":        field(graphql.String, func(d Diagnosis) interface{} { return d.Status }),
+			"dateDiagnosed": field(graphql.DateTime, func(d Diagnosis) interface{} { return d.DateDiagnosed }),
+		},
+	})
+
+	prescriptionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Prescription",
+		Fields: graphql.Fields{
+			"medicationName": field(graphql.String, func(p Prescription) interface{} { return p.MedicationName }),
+			"dosage":         field(graphql.String, func(p Prescription) interface{} { return p.Dosage }),
+			"frequency":      field(graphql.String, func(p Prescription) interface{} { return p.Frequency }),
+			"duration":       field(graphql.String, func(p Prescription) interface{} { return p.Duration }),
+			"instructions":   field(graphql.String, func(p Prescription) interface{} { return p.Instructions }),
+			"prescribedDate": field(graphql.DateTime, func(p Prescription) interface{} { return p.PrescribedDate }),
+			"startDate":      field(graphql.DateTime, func(p Prescription) interface{} { return p.StartDate }),
+			"endDate":        field(graphql.DateTime, func(p Prescription) interface{} { return p.EndDate }),
+		},
+	})
+
+	labResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LabResult",
+		Fields: graphql.Fields{
+			"testName":       field(graphql.String, func(l LabResult) interface{} { return l.TestName }),
+			"testCode":       field(graphql.String, func(l LabResult) interface{} { return l.TestCode }),
+			"result":         field(graphql.String, func(l LabResult) interface{} { return l.Result }),
+			"numericValue":   field(graphql.Float, func(l LabResult) interface{} { return optionalFloat(l.NumericValue) }),
+			"valueType":      field(graphql.String, func(l LabResult) interface{} { return l.ValueType }),
+			"unit":           field(graphql.String, func(l LabResult) interface{} { return l.Unit }),
+			"referenceRange": field(graphql.String, func(l LabResult) interface{} { return l.ReferenceRange }),
+			"status":         field(graphql.String, func(l LabResult) interface{} { return l.Status }),
+			"testDate":       field(graphql.DateTime, func(l LabResult) interface{} { return l.TestDate }),
+			"labName":        field(graphql.String, func(l LabResult) interface{} { return l.LabName }),
+		},
+	})
+
+	recordType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MedicalRecord",
+		Fields: graphql.Fields{
+			"id":             field(graphql.ID, func(r MedicalRecord) interface{} { return r.ID.Hex() }),
+			"patientId":      field(graphql.String, func(r MedicalRecord) interface{} { return r.PatientID }),
+			"doctorId":       field(graphql.String, func(r MedicalRecord) interface{} { return r.DoctorID }),
+			"appointmentId":  field(graphql.String, func(r MedicalRecord) interface{} { return r.AppointmentID }),
+			"recordType":     field(graphql.String, func(r MedicalRecord) interface{} { return r.RecordType }),
+			"title":          field(graphql.String, func(r MedicalRecord) interface{} { return r.Title }),
+			"description":    field(graphql.String, func(r MedicalRecord) interface{} { return r.Description }),
+			"diagnosis":      field(graphql.NewList(diagnosisType), func(r MedicalRecord) interface{} { return r.Diagnosis }),
+			"prescriptions":  field(graphql.NewList(prescriptionType), func(r MedicalRecord) interface{} { return r.Prescriptions }),
+			"labResults":     field(graphql.NewList(labResultType), func(r MedicalRecord) interface{} { return r.LabResults }),
+			"isConfidential": field(graphql.Boolean, func(r MedicalRecord) interface{} { return r.IsConfidential }),
+			"createdAt":      field(graphql.DateTime, func(r MedicalRecord) interface{} { return r.CreatedAt }),
+			"updatedAt":      field(graphql.DateTime, func(r MedicalRecord) interface{} { return r.UpdatedAt }),
+			"createdBy":      field(graphql.String, func(r MedicalRecord) interface{} { return r.CreatedBy }),
+			"lastModifiedBy": field(graphql.String, func(r MedicalRecord) interface{} { return r.LastModifiedBy }),
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"record": &graphql.Field{
+				Type: recordType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: resolveRecord,
+			},
+			"records": &graphql.Field{
+				Type: graphql.NewList(recordType),
+				Args: graphql.FieldConfigArgument{
+					"patientId":  &graphql.ArgumentConfig{Type: graphql.String},
+					"recordType": &graphql.ArgumentConfig{Type: graphql.String},
+					"page":       &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+					"limit":      &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+				},
+				Resolve: resolveRecords,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+func resolveRecord(p graphql.ResolveParams) (interface{}, error) {
+	id, _ := p.Args["id"].(string)
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("invalid record ID")
+	}
+
+	ctx, cancel := context.WithTimeout(p.Context, dbReadTimeout)
+	defer cancel()
+
+	record, err := findRecord(ctx, objectID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch medical record")
+		return nil, errors.New("failed to fetch record")
+	}
+	return record, nil
+}
+
+func resolveRecords(p graphql.ResolveParams) (interface{}, error) {
+	page, _ := p.Args["page"].(int)
+	limit, _ := p.Args["limit"].(int)
+	if page < 1 {
+		return nil, errors.New("page must be a positive integer")
+	}
+	if limit < 1 {
+		return nil, errors.New("limit must be a positive integer")
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	query := RecordQuery{
+		Sort:  []SortField{{Field: "created_at", Descending: true}, {Field: "_id", Descending: true}},
+		Skip:  (page - 1) * limit,
+		Limit: limit,
+	}
+	if patientID, ok := p.Args["patientId"].(string); ok && patientID != "" {
+		query.PatientID = normalizeID(patientID)
+	}
+	if recordType, ok := p.Args["recordType"].(string); ok && recordType != "" {
+		query.RecordTypes = []string{recordType}
+	}
+
+	ctx, cancel := context.WithTimeout(p.Context, dbReadTimeout)
+	defer cancel()
+
+	records, err := findRecords(ctx, query)
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch medical records")
+		return nil, errors.New("failed to fetch records")
+	}
+	return records, nil
+}
+
+// graphqlHandler runs a GraphQL query sent as a JSON body or, on GET, in
+// the query string.
+//
+// @Summary Run a GraphQL query
+// @Tags GraphQL
+// @Accept json
+// @Produce json
+// @Param request body graphqlRequest false "The query, on POST"
+// @Param query query string false "The query, on GET"
+// @Param operationName query string false "Operation to run, on GET"
+// @Success 200 {object} object{data=object,errors=[]object}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 413 {object} apiError "Request body too large"
+// @Security BearerAuth
+// @Router /graphql [post]
+// @Router /graphql [get]
+func graphqlHandler(c *gin.Context) {
+	var req graphqlRequest
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        withOrganization(c.Request.Context(), currentOrganization(c)),
+	})
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// historyCollection keeps a snapshot of a record taken before each change,
+// so the state of a record at any past revision can be retrieved.
+const historyCollection = "medical_record_history"
+
+type RecordRevision struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	RecordID   primitive.ObjectID `bson:"record_id" json:"record_id"`
+	Revision   int                `bson:"revision" json:"revision"`
+	Snapshot   MedicalRecord      `bson:"snapshot" json:"snapshot"`
+	ArchivedAt time.Time          `bson:"archived_at" json:"archived_at"`
+}
+
+// saveRevision stores record as the next revision in its history. Revision
+// numbers start at 1 and are unique per record.
+func saveRevision(ctx context.Context, record MedicalRecord) error {
+	return recordStore.SaveRevision(ctx, record)
+}
+
+// getRecordHistory lists the saved revisions of a record.
+//
+// @Summary List a record's revisions
+// @Tags History
+// @Produce json
+// @Param id path string true "Record ID"
+// @Success 200 {object} object{record_id=string,revisions=[]RecordRevision,total=int}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/history [get]
+func getRecordHistory(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	revisions, err := recordStore.Revisions(ctx, objectID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch record history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"record_id": objectID.Hex(),
+		"revisions": revisions,
+		"total":     len(revisions),
+	})
+}
+
+// getRecordRevision returns one saved revision of a record.
+//
+// @Summary Get one revision
+// @Tags History
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param rev path int true "Revision number" minimum(1)
+// @Success 200 {object} RecordRevision
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Revision not found"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/history/{rev} [get]
+func getRecordRevision(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	rev, err := strconv.Atoi(c.Param("rev"))
+	if err != nil || rev < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	revision, err := recordStore.Revision(ctx, objectID, rev)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			return
+		}
+		logger.WithError(err).Error("Failed to fetch record revision")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revision"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+// applyRecordUpdate lets change modify the record on behalf of user,
+// stamping the modification time and user, and saves a revision of the
+// record as it was, in one transaction. It returns the updated record,
+// ErrRecordNotFound when the record does not exist and a
+// *RecordLockedError when another user holds its edit lock. An error from
+// change is returned as is and nothing is stored. change may be called
+// more than once, as for RecordStore.Update.
+func applyRecordUpdate(ctx context.Context, id primitive.ObjectID, user string, change func(record *MedicalRecord) error) (MedicalRecord, error) {
+	var updated MedicalRecord
+	err := runInTransaction(ctx, func(ctx context.Context) error {
+		var previous MedicalRecord
+		var err error
+		previous, updated, err = recordStore.Update(ctx, id, func(record *MedicalRecord) error {
+			if err := checkRecordLock(*record, user); err != nil {
+				return err
+			}
+			if err := change(record); err != nil {
+				return err
+			}
+			record.UpdatedAt = time.Now().UTC()
+			record.LastModifiedBy = user
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return saveRevision(ctx, previous)
+	})
+	return updated, err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// idempotencyKeysCollection stores Idempotency-Key headers seen on record
+// creation together with the ID of the record they produced. Entries expire
+// through a TTL index on created_at.
+const idempotencyKeysCollection = "idempotency_keys"
+
+type idempotencyKey struct {
+	Key       string             `bson:"_id"`
+	RecordID  primitive.ObjectID `bson:"record_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// organizationKeyID namespaces key by the organization of ctx, so clinics
+// choosing the same key do not see each other's records.
+func organizationKeyID(ctx context.Context, key string) string {
+	return organizationFrom(ctx) + "/" + key
+}
+
+// claimIdempotencyKey reserves key for recordID. If the key was already used
+// it returns the record ID it was first claimed for and false.
+func claimIdempotencyKey(ctx context.Context, key string, recordID primitive.ObjectID) (primitive.ObjectID, bool, error) {
+	return recordStore.ClaimIdempotencyKey(ctx, organizationKeyID(ctx, key), recordID)
+}
+
+// releaseIdempotencyKey removes a claimed key so the client can retry after
+// a failed insert.
+func releaseIdempotencyKey(ctx context.Context, key string) {
+	if err := recordStore.ReleaseIdempotencyKey(ctx, organizationKeyID(ctx, key)); err != nil {
+		logger.WithError(err).Error("Failed to release idempotency key")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateMedicalRecordReplaysIdempotencyKey(t *testing.T) {
+	api := newTestAPI(t)
+	body := recordUpdate("Annual checkup")
+
+	create := func(caller testCaller, key string, body map[string]interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		return api.doWithHeaders(caller, http.MethodPost, "/api/v1/medical-records", body, map[string]string{"Idempotency-Key": key})
+	}
+
+	first := create(testDoctor, "retry-1", body)
+	expectStatus(t, first, http.StatusCreated)
+	created := decodeBody[MedicalRecord](t, first)
+
+	replay := create(testDoctor, "retry-1", body)
+	expectStatus(t, replay, http.StatusCreated)
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay is missing the Idempotent-Replayed header")
+	}
+	if replayed := decodeBody[MedicalRecord](t, replay); replayed.ID != created.ID {
+		t.Errorf("replayed record = %s, want %s", replayed.ID.Hex(), created.ID.Hex())
+	}
+	if count, _ := api.store.Count(testDoctor.context(), RecordFilter{}); count != 1 {
+		t.Errorf("stored records = %d, want 1", count)
+	}
+
+	// Keys are per organization
+	other := create(testOtherClinic, "retry-1", body)
+	expectStatus(t, other, http.StatusCreated)
+	if other.Header().Get("Idempotent-Replayed") != "" || decodeBody[MedicalRecord](t, other).ID == created.ID {
+		t.Error("another organization's request replayed the first organization's record")
+	}
+}
+
+func TestCreateMedicalRecordReleasesKeyOnFailure(t *testing.T) {
+	api := newTestAPI(t)
+	api.seed(testDoctor, MedicalRecord{AppointmentID: "APT-1"})
+	headers := map[string]string{"Idempotency-Key": "retry-2"}
+
+	duplicate := recordUpdate("Checkup")
+	duplicate["appointment_id"] = "APT-1"
+	w := api.doWithHeaders(testDoctor, http.MethodPost, "/api/v1/medical-records", duplicate, headers)
+	expectStatus(t, w, http.StatusConflict)
+
+	// The failed request must not leave the key pointing at nothing
+	duplicate["appointment_id"] = "APT-2"
+	w = api.doWithHeaders(testDoctor, http.MethodPost, "/api/v1/medical-records", duplicate, headers)
+	expectStatus(t, w, http.StatusCreated)
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("retry after a failed create was treated as a replay")
+	}
+}
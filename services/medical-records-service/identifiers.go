@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultIDPattern accepts normalized IDs such as "P001" or "DR-42".
+const defaultIDPattern = `^[A-Z0-9][A-Z0-9_-]{0,63}$`
+
+// patientIDPattern and doctorIDPattern are the formats normalized patient
+// and doctor IDs must match (PATIENT_ID_PATTERN, DOCTOR_ID_PATTERN).
+var (
+	patientIDPattern = regexp.MustCompile(defaultIDPattern)
+	doctorIDPattern  = regexp.MustCompile(defaultIDPattern)
+)
+
+// loadIDPatterns applies PATIENT_ID_PATTERN and DOCTOR_ID_PATTERN from the
+// environment. Invalid expressions are logged and the default kept.
+func loadIDPatterns() {
+	for key, pattern := range map[string]**regexp.Regexp{
+		"PATIENT_ID_PATTERN": &patientIDPattern,
+		"DOCTOR_ID_PATTERN":  &doctorIDPattern,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(value)
+		if err != nil {
+			logger.WithError(err).WithField("key", key).Warnf("Invalid ID pattern %q, using default", value)
+			continue
+		}
+		*pattern = compiled
+	}
+}
+
+// normalizeID trims and uppercases an ID so "p001 " and "P001" are stored
+// and looked up the same way. Query parameters go through it too.
+func normalizeID(id string) string {
+	return strings.ToUpper(strings.TrimSpace(id))
+}
+
+// IDFormatError reports a patient or doctor ID that does not match its
+// configured format.
+type IDFormatError struct {
+	Field string
+	Value string
+}
+
+func (e *IDFormatError) Error() string {
+	return fmt.Sprintf("%s %q is not in the expected format", e.Field, e.Value)
+}
+
+// normalizeRecordIDs normalizes a record's patient and doctor IDs in place
+// and checks them against their formats.
+func normalizeRecordIDs(record *MedicalRecord) error {
+	record.PatientID = normalizeID(record.PatientID)
+	record.DoctorID = normalizeID(record.DoctorID)
+
+	if !patientIDPattern.MatchString(record.PatientID) {
+		return &IDFormatError{Field: "patient_id", Value: record.PatientID}
+	}
+	if !doctorIDPattern.MatchString(record.DoctorID) {
+		return &IDFormatError{Field: "doctor_id", Value: record.DoctorID}
+	}
+	return nil
+}
+
+// respondIDFormatError writes a 400 naming the malformed ID field.
+func respondIDFormatError(c *gin.Context, err error) {
+	formatErr, ok := err.(*IDFormatError)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": formatErr.Error(), "field": formatErr.Field})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// InteractionRule describes a known interaction between two medications.
+type InteractionRule struct {
+	DrugA       string `json:"drug_a"`
+	DrugB       string `json:"drug_b"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// RecordWarning is a non-blocking issue reported alongside a successful
+// write, such as a potential drug interaction or a missing recommended
+// field.
+type RecordWarning struct {
+	Type     string `json:"type"`
+	Field    string `json:"field,omitempty"`
+	Message  string `json:"message"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// interactionRules is keyed by the normalized medication pair, see
+// interactionKey.
+var interactionRules map[string]InteractionRule
+
+func normalizeMedication(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func interactionKey(a, b string) string {
+	a, b = normalizeMedication(a), normalizeMedication(b)
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// loadInteractionRules reads the interaction rules table from a JSON file
+// containing an array of InteractionRule. An empty path disables checks.
+func loadInteractionRules(path string) (map[string]InteractionRule, error) {
+	rules := make(map[string]InteractionRule)
+	if path == "" {
+		return rules, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+
+	var list []InteractionRule
+	if err := json.Unmarshal(data, &list); err != nil {
+		return rules, err
+	}
+
+	for _, rule := range list {
+		rules[interactionKey(rule.DrugA, rule.DrugB)] = rule
+	}
+	return rules, nil
+}
+
+// activeMedications returns the names of the patient's prescriptions that
+// have not yet ended.
+func activeMedications(ctx context.Context, patientID string) ([]string, error) {
+	records, err := findRecords(ctx, RecordQuery{
+		RecordFilter: RecordFilter{PatientID: patientID, HasPrescriptions: true},
+		Fields:       []string{"prescriptions"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var medications []string
+	for _, record := range records {
+		for _, prescription := range record.Prescriptions {
+			if prescription.EndDate.IsZero() || prescription.EndDate.After(now) {
+				medications = append(medications, prescription.MedicationName)
+			}
+		}
+	}
+	return medications, nil
+}
+
+// checkDrugInteractions compares the prescriptions on a new record against
+// each other and against the patient's active medications, returning a
+// warning for every matching interaction rule.
+func checkDrugInteractions(ctx context.Context, record *MedicalRecord) ([]RecordWarning, error) {
+	if len(interactionRules) == 0 || len(record.Prescriptions) == 0 {
+		return nil, nil
+	}
+
+	existing, err := activeMedications(ctx, record.PatientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []RecordWarning
+	seen := make(map[string]bool)
+	check := func(a, b string) {
+		key := interactionKey(a, b)
+		rule, ok := interactionRules[key]
+		if !ok || seen[key] {
+			return
+		}
+		seen[key] = true
+		warnings = append(warnings, RecordWarning{
+			Type:     "drug_interaction",
+			Message:  fmt.Sprintf("%s interacts with %s: %s", a, b, rule.Description),
+			Severity: rule.Severity,
+		})
+	}
+
+	for i, prescription := range record.Prescriptions {
+		for _, other := range record.Prescriptions[i+1:] {
+			check(prescription.MedicationName, other.MedicationName)
+		}
+		for _, medication := range existing {
+			check(prescription.MedicationName, medication)
+		}
+	}
+	return warnings, nil
+}
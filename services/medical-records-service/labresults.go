@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// parseNumericResult reads a lab result that is a plain number, such as
+// "5.4" or "-2". Qualified values like "<0.1" and text like "positive" are
+// not numeric.
+func parseNumericResult(result string) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(result), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+// typeLabResult sets result's ValueType and NumericValue from its Result
+// string, which is kept as sent for display. NumericValue is always derived
+// rather than taken from the client. A result that is not a number is text
+// unless the client marked it coded.
+func typeLabResult(result *LabResult) {
+	result.NumericValue = nil
+	if value, ok := parseNumericResult(result.Result); ok {
+		result.NumericValue = &value
+		result.ValueType = "numeric"
+		return
+	}
+	if result.ValueType != "coded" {
+		result.ValueType = "text"
+	}
+}
+
+// typeLabResults applies typeLabResult to each of results.
+func typeLabResults(results []LabResult) {
+	for i := range results {
+		typeLabResult(&results[i])
+	}
+}
+
+// labStatusChange is one test code to move to a new status.
+type labStatusChange struct {
+	TestCode string
+	Status   string
+}
+
+// parseLabStatusChanges validates a test_code to status mapping and returns
+// it sorted by test code.
+func parseLabStatusChanges(statuses map[string]string) ([]labStatusChange, error) {
+	if len(statuses) == 0 {
+		return nil, fmt.Errorf("at least one test_code must be given")
+	}
+	if len(statuses) > maxBatchSize {
+		return nil, fmt.Errorf("at most %d test codes may be updated at once", maxBatchSize)
+	}
+
+	changes := make([]labStatusChange, 0, len(statuses))
+	for code, status := range statuses {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			return nil, fmt.Errorf("test_code must not be empty")
+		}
+		if err := validate.Var(status, "oneof=normal abnormal critical"); err != nil {
+			return nil, fmt.Errorf("status for %s must be one of normal, abnormal, critical", code)
+		}
+		changes = append(changes, labStatusChange{TestCode: code, Status: status})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].TestCode < changes[j].TestCode })
+	return changes, nil
+}
+
+// labStatusChangeFor returns the change that applies to result, if any.
+// Results already in the target status are left alone.
+func labStatusChangeFor(result LabResult, changes []labStatusChange) (labStatusChange, bool) {
+	for _, change := range changes {
+		if result.TestCode == change.TestCode && result.Status != change.Status {
+			return change, true
+		}
+	}
+	return labStatusChange{}, false
+}
+
+// updateLabResultStatuses sets the status of a patient's lab results by
+// test code across all of their records, as when a lab batch is finalized.
+// The body maps test_code to the new status. All records change in one
+// transaction and a history revision is saved for each record changed.
+// Results moving to critical raise the usual alerts.
+//
+// @Summary Set lab result statuses by test code
+// @Tags Patients
+// @Accept json
+// @Produce json
+// @Param patient_id path string true "Patient ID"
+// @Param statuses body map[string]string true "Maps test_code to normal, abnormal or critical"
+// @Success 200 {object} object{patient_id=string,updated=int,records_updated=int}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "No lab results with these test codes"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/patients/{patient_id}/lab-results/status [patch]
+func updateLabResultStatuses(c *gin.Context) {
+	patientID := normalizeID(c.Param("patient_id"))
+
+	var statuses map[string]string
+	if err := c.ShouldBindJSON(&statuses); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	changes, err := parseLabStatusChanges(statuses)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	testCodes := make([]string, 0, len(changes))
+	for _, change := range changes {
+		testCodes = append(testCodes, change.TestCode)
+	}
+	filter := RecordFilter{PatientID: patientID, LabTestCodes: testCodes}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	var records []MedicalRecord
+	var updated int
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		records, updated = nil, 0
+		candidates, err := findRecords(ctx, RecordQuery{RecordFilter: filter})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, record := range candidates {
+			changed := 0
+			for _, result := range record.LabResults {
+				if _, ok := labStatusChangeFor(result, changes); ok {
+					changed++
+				}
+			}
+			if changed == 0 {
+				continue
+			}
+
+			previous, _, err := recordStore.Update(ctx, record.ID, func(r *MedicalRecord) error {
+				for i := range r.LabResults {
+					if change, ok := labStatusChangeFor(r.LabResults[i], changes); ok {
+						r.LabResults[i].Status = change.Status
+					}
+				}
+				r.UpdatedAt = now
+				r.LastModifiedBy = currentUser(c)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := saveRevision(ctx, previous); err != nil {
+				return err
+			}
+			records = append(records, record)
+			updated += changed
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		entry := newAuditEntry(c, "lab_results_status_update")
+		entry.PatientID = patientID
+		entry.Details = map[string]interface{}{
+			"statuses":        statuses,
+			"results_updated": updated,
+			"records_updated": len(records),
+		}
+		return writeAudit(ctx, entry)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to update lab result statuses")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lab results"})
+		return
+	}
+
+	// Alerts go out only once the change has committed
+	for _, record := range records {
+		var critical []LabResult
+		for _, result := range record.LabResults {
+			if change, ok := labStatusChangeFor(result, changes); ok && change.Status == "critical" {
+				result.Status = change.Status
+				critical = append(critical, result)
+			}
+		}
+		if len(critical) > 0 {
+			notifyCriticalLabResults(record, critical)
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"results_updated": updated,
+		"records_updated": len(records),
+	}).Info("Lab result statuses updated")
+	c.JSON(http.StatusOK, gin.H{
+		"patient_id":      patientID,
+		"updated":         updated,
+		"records_updated": len(records),
+	})
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errDifferentPatients is returned when linking records of two patients.
+var errDifferentPatients = errors.New("linked records must belong to the same patient")
+
+// errNotLinked is returned when unlinking records that are not linked.
+var errNotLinked = errors.New("records are not linked")
+
+type linkRecordRequest struct {
+	RelatedID string `json:"related_id" binding:"required"`
+}
+
+// linkRecords links a record to another of the same patient, such as a
+// lab result to the consultation that ordered it. Links are kept on both
+// records so either can be navigated from the other.
+//
+// @Summary Link a record to another of the same patient
+// @Tags Links
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param request body linkRecordRequest true "The record to link"
+// @Success 200 {object} MedicalRecord
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/links [post]
+func linkRecords(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	var req linkRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	relatedID, err := primitive.ObjectIDFromHex(req.RelatedID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid related record ID", "field": "related_id"})
+		return
+	}
+	if relatedID == objectID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A record cannot be linked to itself", "field": "related_id"})
+		return
+	}
+
+	updated, err := setRecordLink(c, objectID, relatedID, true)
+	if err != nil {
+		respondRecordLinkError(c, err)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"record_id":  objectID.Hex(),
+		"related_id": relatedID.Hex(),
+	}).Info("Medical records linked")
+	c.JSON(http.StatusOK, updated)
+}
+
+// unlinkRecords removes the link between two records from both of them.
+//
+// @Summary Remove a link between two records
+// @Tags Links
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param related_id path string true "ID of the linked record"
+// @Success 200 {object} MedicalRecord
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/links/{related_id} [delete]
+func unlinkRecords(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+	relatedID, err := primitive.ObjectIDFromHex(c.Param("related_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid related record ID"})
+		return
+	}
+
+	updated, err := setRecordLink(c, objectID, relatedID, false)
+	if err != nil {
+		respondRecordLinkError(c, err)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"record_id":  objectID.Hex(),
+		"related_id": relatedID.Hex(),
+	}).Info("Medical records unlinked")
+	c.JSON(http.StatusOK, updated)
+}
+
+// setRecordLink adds or removes the link between two records on both
+// sides in one transaction, saving a revision of each, and returns the
+// first record as updated. Either record being locked by another user
+// blocks the change.
+func setRecordLink(c *gin.Context, id, relatedID primitive.ObjectID, link bool) (MedicalRecord, error) {
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	var updated MedicalRecord
+	err := runInTransaction(ctx, func(ctx context.Context) error {
+		record, err := findRecord(ctx, id)
+		if err != nil {
+			return err
+		}
+		related, err := findRecord(ctx, relatedID)
+		if err != nil {
+			return err
+		}
+		for _, r := range []MedicalRecord{record, related} {
+			if err := checkRecordLock(r, currentUser(c)); err != nil {
+				return err
+			}
+		}
+		if link && record.PatientID != related.PatientID {
+			return errDifferentPatients
+		}
+		if !link && !slices.Contains(record.RelatedRecordIDs, relatedID.Hex()) {
+			return errNotLinked
+		}
+
+		// The first record goes last, so updated ends up holding it
+		for _, pair := range [][2]MedicalRecord{{related, record}, {record, related}} {
+			otherID := pair[1].ID.Hex()
+			updated, err = applyRecordUpdate(ctx, pair[0].ID, currentUser(c), func(r *MedicalRecord) error {
+				r.RelatedRecordIDs = slices.DeleteFunc(r.RelatedRecordIDs, func(linked string) bool {
+					return linked == otherID
+				})
+				if link {
+					r.RelatedRecordIDs = append(r.RelatedRecordIDs, otherID)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// respondRecordLinkError maps errors from setRecordLink to responses.
+func respondRecordLinkError(c *gin.Context, err error) {
+	var lockErr *RecordLockedError
+	switch {
+	case errors.As(err, &lockErr):
+		respondRecordLocked(c, lockErr)
+	case errors.Is(err, ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+	case errors.Is(err, errDifferentPatients):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "field": "related_id"})
+	case errors.Is(err, errNotLinked):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Records are not linked"})
+	default:
+		logger.WithError(err).Error("Failed to update record links")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update record links"})
+	}
+}
+
+// getRelatedRecords returns the records linked to a record, with the same
+// summary projection and fields parameter as listings. Linked records that
+// have since been deleted are left out.
+//
+// @Summary Records linked to a record
+// @Tags Links
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param fields query string false "Comma-separated record fields to return"
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Success 200 {object} object{record_id=string,related=[]MedicalRecord,total=int}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/related [get]
+func getRelatedRecords(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+	projection, err := parseProjection(c.Query("fields"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	record, err := findRecord(ctx, objectID)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+			return
+		}
+		logger.WithError(err).Error("Failed to fetch medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch record"})
+		return
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(record.RelatedRecordIDs))
+	for _, hex := range record.RelatedRecordIDs {
+		if id, err := primitive.ObjectIDFromHex(hex); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	related, err := findRecords(ctx, RecordQuery{
+		RecordFilter: RecordFilter{IDs: ids},
+		Sort:         []SortField{{Field: "created_at"}, {Field: "_id"}},
+		Fields:       projection,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch related records")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch related records"})
+		return
+	}
+	localizeRecords(related, loc)
+
+	c.JSON(http.StatusOK, gin.H{
+		"record_id": objectID.Hex(),
+		"related":   related,
+		"total":     len(related),
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordLockTTL is how long an edit lock lasts (RECORD_LOCK_TTL). Locking
+// again before it expires renews it.
+var recordLockTTL = 15 * time.Minute
+
+// RecordLockedError is returned when another user holds a record's edit
+// lock.
+type RecordLockedError struct {
+	LockedBy  string
+	ExpiresAt time.Time
+}
+
+func (e *RecordLockedError) Error() string {
+	return fmt.Sprintf("record is locked by %s until %s", e.LockedBy, e.ExpiresAt.Format(time.RFC3339))
+}
+
+// lockedByOther reports whether record holds an unexpired lock owned by
+// someone other than user.
+func lockedByOther(record MedicalRecord, user string, now time.Time) bool {
+	return record.LockedBy != "" && record.LockedBy != user &&
+		record.LockExpiresAt != nil && record.LockExpiresAt.After(now)
+}
+
+// checkRecordLock is the edit-lock guard for record writes: it returns a
+// *RecordLockedError when another user holds record's lock.
+func checkRecordLock(record MedicalRecord, user string) error {
+	if lockedByOther(record, user, time.Now()) {
+		return &RecordLockedError{LockedBy: record.LockedBy, ExpiresAt: *record.LockExpiresAt}
+	}
+	return nil
+}
+
+// respondRecordLocked writes a 423 naming the lock holder and expiry.
+func respondRecordLocked(c *gin.Context, err *RecordLockedError) {
+	c.JSON(http.StatusLocked, gin.H{
+		"error":           "Medical record is locked by another user",
+		"locked_by":       err.LockedBy,
+		"lock_expires_at": err.ExpiresAt,
+	})
+}
+
+// lockRecord takes or renews the caller's edit lock on a record. Locks are
+// advisory intent signals: while one is held, updates from other users are
+// rejected until it is released or expires.
+//
+// @Summary Take or renew the edit lock
+// @Tags Locks
+// @Produce json
+// @Param id path string true "Record ID"
+// @Success 200 {object} object{id=string,locked_by=string,lock_expires_at=string}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/lock [post]
+func lockRecord(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+	user := currentUser(c)
+	if user == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required to lock a record"})
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(recordLockTTL)
+
+	// Only take the lock if it is free, already ours, or expired
+	_, _, err = recordStore.Update(ctx, objectID, func(record *MedicalRecord) error {
+		if err := checkRecordLock(*record, user); err != nil {
+			return err
+		}
+		record.LockedBy = user
+		record.LockExpiresAt = &expiresAt
+		return nil
+	})
+	if err != nil {
+		respondLockError(c, err, "Failed to lock record")
+		return
+	}
+
+	logger.WithField("record_id", objectID.Hex()).Info("Medical record locked")
+	c.JSON(http.StatusOK, gin.H{
+		"id":              objectID.Hex(),
+		"locked_by":       user,
+		"lock_expires_at": expiresAt,
+	})
+}
+
+// unlockRecord releases the caller's edit lock. Releasing a record that is
+// not locked succeeds, so clients can always call it when leaving an edit.
+//
+// @Summary Release the edit lock
+// @Tags Locks
+// @Produce json
+// @Param id path string true "Record ID"
+// @Success 204 "Released, or was not locked"
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/lock [delete]
+func unlockRecord(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+	user := currentUser(c)
+	if user == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required to unlock a record"})
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	_, _, err = recordStore.Update(ctx, objectID, func(record *MedicalRecord) error {
+		if record.LockedBy != user {
+			if err := checkRecordLock(*record, user); err != nil {
+				return err
+			}
+			return errNotLockHolder
+		}
+		record.LockedBy = ""
+		record.LockExpiresAt = nil
+		return nil
+	})
+	if errors.Is(err, errNotLockHolder) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		respondLockError(c, err, "Failed to unlock record")
+		return
+	}
+
+	logger.WithField("record_id", objectID.Hex()).Info("Medical record unlocked")
+	c.Status(http.StatusNoContent)
+}
+
+// errNotLockHolder is returned when unlocking a record the caller holds no
+// lock on, which leaves it unchanged.
+var errNotLockHolder = errors.New("record is not locked by the caller")
+
+// respondLockError maps errors from taking or releasing a lock to
+// responses, logging message for unexpected ones.
+func respondLockError(c *gin.Context, err error, message string) {
+	var lockErr *RecordLockedError
+	switch {
+	case errors.As(err, &lockErr):
+		respondRecordLocked(c, lockErr)
+	case errors.Is(err, ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+	default:
+		logger.WithError(err).Error(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"reflect"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
@@ -27,26 +41,45 @@ var (
 	validate          *validator.Validate
 	requestCounter    *prometheus.CounterVec
 	requestDuration   *prometheus.HistogramVec
+	responseBytes     *prometheus.HistogramVec
+	defaultPageLimit  int
+	maxPageLimit      int
+	maxBatchSize      int
+	idempotencyTTL    time.Duration
+
+	// recordsCollectionName is the collection holding medical records
+	// (COLLECTION_NAME), so several datasets can share one database
+	recordsCollectionName = "medical_records"
+
+	// Patient summary aggregation limits
+	summaryTimeout      time.Duration
+	summaryPartialLimit int
 )
 
 type MedicalRecord struct {
 	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	OrganizationID   string             `bson:"organization_id" json:"organization_id"`
+	ReferenceNumber  string             `bson:"reference_number,omitempty" json:"reference_number,omitempty"`
 	PatientID        string             `bson:"patient_id" json:"patient_id" validate:"required"`
 	DoctorID         string             `bson:"doctor_id" json:"doctor_id" validate:"required"`
 	AppointmentID    string             `bson:"appointment_id" json:"appointment_id"`
 	RecordType       string             `bson:"record_type" json:"record_type" validate:"required,oneof=consultation diagnosis prescription lab_result imaging"`
 	Title            string             `bson:"title" json:"title" validate:"required"`
 	Description      string             `bson:"description" json:"description"`
-	Diagnosis        []Diagnosis        `bson:"diagnosis" json:"diagnosis"`
-	Prescriptions    []Prescription     `bson:"prescriptions" json:"prescriptions"`
-	LabResults       []LabResult        `bson:"lab_results" json:"lab_results"`
+	Diagnosis        []Diagnosis        `bson:"diagnosis" json:"diagnosis" validate:"dive"`
+	Prescriptions    []Prescription     `bson:"prescriptions" json:"prescriptions" validate:"dive"`
+	LabResults       []LabResult        `bson:"lab_results" json:"lab_results" validate:"dive"`
 	VitalSigns       *VitalSigns        `bson:"vital_signs" json:"vital_signs"`
-	Attachments      []Attachment       `bson:"attachments" json:"attachments"`
+	Attachments      []Attachment       `bson:"attachments" json:"attachments" validate:"dive"`
 	IsConfidential   bool               `bson:"is_confidential" json:"is_confidential"`
+	Consent          *Consent           `bson:"consent,omitempty" json:"consent,omitempty" validate:"omitempty"`
+	RelatedRecordIDs []string           `bson:"related_record_ids,omitempty" json:"related_record_ids,omitempty"`
 	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
 	CreatedBy        string             `bson:"created_by" json:"created_by"`
 	LastModifiedBy   string             `bson:"last_modified_by" json:"last_modified_by"`
+	LockedBy         string             `bson:"locked_by,omitempty" json:"locked_by,omitempty"`
+	LockExpiresAt    *time.Time         `bson:"lock_expires_at,omitempty" json:"lock_expires_at,omitempty"`
 }
 
 type Diagnosis struct {
@@ -66,12 +99,15 @@ type Prescription struct {
 	PrescribedDate time.Time `bson:"prescribed_date" json:"prescribed_date"`
 	StartDate      time.Time `bson:"start_date" json:"start_date"`
 	EndDate        time.Time `bson:"end_date" json:"end_date"`
+	Expired        bool      `bson:"expired" json:"expired"`
 }
 
 type LabResult struct {
 	TestName     string    `bson:"test_name" json:"test_name" validate:"required"`
 	TestCode     string    `bson:"test_code" json:"test_code"`
 	Result       string    `bson:"result" json:"result" validate:"required"`
+	NumericValue *float64  `bson:"numeric_value,omitempty" json:"numeric_value,omitempty"`
+	ValueType    string    `bson:"value_type,omitempty" json:"value_type,omitempty" validate:"omitempty,oneof=numeric text coded"`
 	Unit         string    `bson:"unit" json:"unit"`
 	ReferenceRange string  `bson:"reference_range" json:"reference_range"`
 	Status       string    `bson:"status" json:"status" validate:"oneof=normal abnormal critical"`
@@ -96,9 +132,10 @@ type Attachment struct {
 	FileName    string    `bson:"file_name" json:"file_name" validate:"required"`
 	FileType    string    `bson:"file_type" json:"file_type" validate:"required"`
 	FileSize    int64     `bson:"file_size" json:"file_size"`
-	StoragePath string    `bson:"storage_path" json:"storage_path"`
+	StoragePath string    `bson:"storage_path" json:"-"`
 	UploadedAt  time.Time `bson:"uploaded_at" json:"uploaded_at"`
 	Description string    `bson:"description" json:"description"`
+	Checksum    string    `bson:"checksum,omitempty" json:"checksum,omitempty"`
 }
 
 func init() {
@@ -107,11 +144,106 @@ func init() {
 
 	// Initialize logger
 	logger = logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetLevel(logrus.InfoLevel)
+	configureLogger(logger, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
 
-	// Initialize validator
+	// Initialize validator, reporting fields by their JSON names so errors
+	// in nested arrays read like "diagnosis[1].severity"
 	validate = validator.New()
+	validate.RegisterTagNameFunc(jsonFieldName)
+	// Request bodies bound by gin report their fields the same way
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(jsonFieldName)
+	}
+
+	// Mask PHI in logs unless explicitly disabled
+	redactPHI = os.Getenv("LOG_REDACT_PHI") != "false"
+
+	// Vital sign ranges
+	loadVitalRanges()
+
+	// Patient and doctor ID formats
+	loadIDPatterns()
+
+	// Cross-origin access for browser clients
+	loadCORSConfig()
+
+	// HTTP server timeouts
+	loadServerTimeouts()
+
+	// Development only: accept the organization from a header when
+	// requests carry no token
+	organizationHeaderAllowed = os.Getenv("ALLOW_ORGANIZATION_HEADER") == "true"
+
+	// Swagger UI, which production deployments may turn off
+	apiDocsEnabled = os.Getenv("API_DOCS_ENABLED") != "false"
+
+	// Whether duplicate active diagnoses are rejected or only warned about
+	duplicateDiagnosisMode = os.Getenv("DUPLICATE_DIAGNOSIS_MODE")
+	if duplicateDiagnosisMode != "reject" {
+		if duplicateDiagnosisMode != "" && duplicateDiagnosisMode != "warn" {
+			logger.Warnf("Invalid DUPLICATE_DIAGNOSIS_MODE %q, using warn", duplicateDiagnosisMode)
+		}
+		duplicateDiagnosisMode = "warn"
+	}
+
+	// Records collection, overridable for separate datasets or test runs
+	if name := os.Getenv("COLLECTION_NAME"); name != "" {
+		recordsCollectionName = name
+	}
+
+	// Records read and items listed per alert type for a patient's alerts
+	maxPatientAlerts = getEnvInt("MAX_PATIENT_ALERTS", 100)
+
+	// Maximum number of IDs per batch-get request
+	maxBatchSize = getEnvInt("MAX_BATCH_SIZE", 100)
+
+	// Response compression
+	compressionLevel = getEnvInt("COMPRESSION_LEVEL", gzip.DefaultCompression)
+	if compressionLevel < gzip.DefaultCompression || compressionLevel > gzip.BestCompression {
+		logger.Warnf("Invalid COMPRESSION_LEVEL %d, using default", compressionLevel)
+		compressionLevel = gzip.DefaultCompression
+	}
+	compressionMinBytes = getEnvInt("COMPRESSION_MIN_BYTES", 1024)
+
+	// Request body limits
+	maxBodyBytes = int64(getEnvInt("MAX_BODY_BYTES", 1<<20))
+	maxUploadBytes = int64(getEnvInt("MAX_UPLOAD_BYTES", 50<<20))
+
+	// Attachment storage and optional malware scanning
+	attachmentDir = os.Getenv("ATTACHMENT_DIR")
+	if attachmentDir == "" {
+		attachmentDir = "/data/attachments"
+	}
+	maxAttachmentsPerRecord = getEnvInt("MAX_ATTACHMENTS_PER_RECORD", 20)
+	maxAttachmentBytesPerRecord = int64(getEnvInt("MAX_ATTACHMENT_BYTES_PER_RECORD", 500<<20))
+
+	// CLAMAV_ADDR is accepted as a shorter alias of CLAMAV_ADDRESS
+	clamavAddress := os.Getenv("CLAMAV_ADDRESS")
+	if clamavAddress == "" {
+		clamavAddress = os.Getenv("CLAMAV_ADDR")
+	}
+	attachmentScanner = newClamAVScanner(clamavAddress, getEnvDuration("CLAMAV_TIMEOUT", 30*time.Second))
+
+	// Critical lab result alerting
+	webhooks = loadWebhookConfig()
+	alertChannels = loadAlertChannels()
+
+	// Pagination limits
+	loadPageLimits()
+
+	// Patient summary time limit and the number of recent records
+	// summarised when it is exceeded
+	summaryTimeout = getEnvDuration("SUMMARY_TIMEOUT", 30*time.Second)
+	summaryPartialLimit = getEnvInt("SUMMARY_PARTIAL_LIMIT", 500)
+
+	// Server-side time limit on list, search and timeline queries
+	queryMaxTime = getEnvDuration("QUERY_MAX_TIME", 20*time.Second)
+
+	// How long an edit lock is held unless released or renewed
+	recordLockTTL = getEnvDuration("RECORD_LOCK_TTL", 15*time.Minute)
+
+	// How long Idempotency-Key headers are remembered
+	idempotencyTTL = getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
 
 	// Initialize Prometheus metrics
 	requestCounter = prometheus.NewCounterVec(
@@ -130,7 +262,126 @@ func init() {
 		[]string{"method", "endpoint"},
 	)
 
-	prometheus.MustRegister(requestCounter, requestDuration)
+	responseBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "medical_records_response_bytes",
+			Help: "Size of response bodies sent by medical records service",
+			// 256B to 4MB
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		},
+		[]string{"endpoint"},
+	)
+
+	prometheus.MustRegister(requestCounter, requestDuration, responseBytes)
+
+	// Load drug interaction rules
+	var err error
+	interactionRules, err = loadInteractionRules(os.Getenv("INTERACTION_RULES_FILE"))
+	if err != nil {
+		logger.WithError(err).Error("Failed to load drug interaction rules, interaction checks disabled")
+	}
+
+	// Initialize GraphQL schema
+	graphqlSchema, err = buildGraphQLSchema()
+	if err != nil {
+		logger.Fatalf("Failed to build GraphQL schema: %v", err)
+	}
+}
+
+// configureLogger applies the LOG_LEVEL and LOG_FORMAT settings. Empty or
+// invalid values fall back to JSON output at info level.
+func configureLogger(l *logrus.Logger, level, format string) {
+	l.SetFormatter(&logrus.JSONFormatter{})
+	l.SetLevel(logrus.InfoLevel)
+
+	switch strings.ToLower(format) {
+	case "", "json":
+	case "text":
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		l.Warnf("Invalid LOG_FORMAT %q, using json", format)
+	}
+
+	switch strings.ToLower(level) {
+	case "":
+	case "debug", "info", "warn", "error":
+		parsed, _ := logrus.ParseLevel(level)
+		l.SetLevel(parsed)
+	default:
+		l.Warnf("Invalid LOG_LEVEL %q, using info", level)
+	}
+}
+
+// getEnvInt reads an integer from the environment, falling back to def when
+// the variable is unset or not a valid integer.
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		logger.WithField("key", key).Warnf("Invalid integer %q, using default %d", value, def)
+		return def
+	}
+	return n
+}
+
+// jsonFieldName names a struct field by its JSON key in validation errors.
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// getEnvDuration reads a duration such as "30s" from the environment, falling
+// back to def when the variable is unset or invalid.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.WithField("key", key).Warnf("Invalid duration %q, using default %s", value, def)
+		return def
+	}
+	return d
+}
+
+// applyPoolOptions configures the connection pool from MONGO_MAX_POOL_SIZE,
+// MONGO_MIN_POOL_SIZE and MONGO_MAX_CONN_IDLE_TIME, keeping the driver
+// defaults for unset values, and logs the effective settings.
+func applyPoolOptions(clientOptions *options.ClientOptions) {
+	if maxPool := getEnvInt("MONGO_MAX_POOL_SIZE", 0); maxPool > 0 {
+		clientOptions.SetMaxPoolSize(uint64(maxPool))
+	}
+	if minPool := getEnvInt("MONGO_MIN_POOL_SIZE", 0); minPool > 0 {
+		clientOptions.SetMinPoolSize(uint64(minPool))
+	}
+	if idle := getEnvDuration("MONGO_MAX_CONN_IDLE_TIME", 0); idle > 0 {
+		clientOptions.SetMaxConnIdleTime(idle)
+	}
+
+	fields := logrus.Fields{}
+	if clientOptions.MaxPoolSize != nil {
+		fields["max_pool_size"] = *clientOptions.MaxPoolSize
+	} else {
+		fields["max_pool_size"] = "default"
+	}
+	if clientOptions.MinPoolSize != nil {
+		fields["min_pool_size"] = *clientOptions.MinPoolSize
+	} else {
+		fields["min_pool_size"] = "default"
+	}
+	if clientOptions.MaxConnIdleTime != nil {
+		fields["max_conn_idle_time"] = clientOptions.MaxConnIdleTime.String()
+	} else {
+		fields["max_conn_idle_time"] = "default"
+	}
+	logger.WithFields(fields).Info("MongoDB connection pool configured")
 }
 
 func connectMongoDB() *mongo.Client {
@@ -163,7 +414,11 @@ func connectMongoDB() *mongo.Client {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	clientOptions := options.Client().ApplyURI(mongoURI).SetMonitor(mongoCommandMonitor())
+	applyPoolOptions(clientOptions)
+	applyConsistencyOptions(clientOptions)
+
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		logger.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
@@ -173,10 +428,105 @@ func connectMongoDB() *mongo.Client {
 		logger.Fatalf("Failed to ping MongoDB: %v", err)
 	}
 
-	logger.Info("Connected to MongoDB successfully")
+	transactionsSupported = detectTransactionSupport(ctx, client)
+
+	logger.WithField("transactions", transactionsSupported).Info("Connected to MongoDB successfully")
 	return client
 }
 
+// ensureIndexes creates the indexes the service relies on. Failures are
+// logged rather than fatal so the service can still start against a
+// read-only or partially provisioned database.
+func ensureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection(idempotencyKeysCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(idempotencyTTL.Seconds())),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to create idempotency key TTL index")
+	}
+	_, err = db.Collection(historyCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "record_id", Value: 1}, {Key: "revision", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to create record history index")
+	}
+
+	// Listing and patient lookups rely on these; readiness checks for them
+	_, err = db.Collection(recordsCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "patient_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		// Every query is scoped to an organization
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "patient_id", Value: 1}}},
+		// Multikey indexes over the nested diagnosis array
+		{Keys: bson.D{{Key: "diagnosis.code", Value: 1}}},
+		{Keys: bson.D{{Key: "diagnosis.severity", Value: 1}}},
+		// Medication search; scanning index keys is cheaper than documents
+		{Keys: bson.D{{Key: "prescriptions.medication_name", Value: 1}}},
+		// Lets the prescription expiry sweep find ended prescriptions
+		{Keys: bson.D{{Key: "prescriptions.end_date", Value: 1}}},
+		// Per-doctor monthly statistics
+		{Keys: bson.D{{Key: "doctor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to create medical record indexes")
+	}
+
+	// Reference numbers are unique; older records without one are skipped
+	_, err = db.Collection(recordsCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "reference_number", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to create reference number index")
+	}
+
+	// The changes feed pages through records and tombstones by timestamp
+	_, err = db.Collection(recordsCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to create updated_at index")
+	}
+	_, err = db.Collection(tombstoneCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "deleted_at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to create tombstone index")
+	}
+
+	// One record per type per appointment in each organization, so retried
+	// POSTs cannot create duplicates. Records without an appointment are
+	// not constrained. Live records all index deleted_at as null, while
+	// each erased record has its own erasure time, so erased records never
+	// hold the slot of a live one.
+	_, err = db.Collection(recordsCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "organization_id", Value: 1},
+			{Key: "patient_id", Value: 1},
+			{Key: "appointment_id", Value: 1},
+			{Key: "record_type", Value: 1},
+			{Key: "deleted_at", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"appointment_id": bson.M{"$gt": ""}}),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to create appointment record uniqueness index")
+	}
+	// The earlier index spanned organizations and erased records
+	_, err = db.Collection(recordsCollectionName).Indexes().DropOne(ctx, "patient_id_1_appointment_id_1_record_type_1")
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound")) {
+		logger.WithError(err).Error("Failed to drop the old appointment record uniqueness index")
+	}
+}
+
 func prometheusMiddleware() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		start := time.Now()
@@ -188,14 +538,19 @@ func prometheusMiddleware() gin.HandlerFunc {
 
 		requestCounter.WithLabelValues(c.Request.Method, c.FullPath(), status).Inc()
 		requestDuration.WithLabelValues(c.Request.Method, c.FullPath()).Observe(duration.Seconds())
+
+		// Measured once the handler and compression are done, so this is
+		// the size sent on the wire. Size is -1 when nothing was written.
+		responseBytes.WithLabelValues(c.FullPath()).Observe(float64(max(c.Writer.Size(), 0)))
 	})
 }
 
+// loggingMiddleware logs one line per request. Request bodies are never
+// logged, and PHI in the path and query is masked unless LOG_REDACT_PHI is
+// disabled.
 func loggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
-		path := c.Request.URL.Path
-		raw := c.Request.URL.RawQuery
 
 		c.Next()
 
@@ -203,10 +558,7 @@ func loggingMiddleware() gin.HandlerFunc {
 		clientIP := c.ClientIP()
 		method := c.Request.Method
 		statusCode := c.Writer.Status()
-
-		if raw != "" {
-			path = path + "?" + raw
-		}
+		path := redactedPath(c)
 
 		logger.WithFields(logrus.Fields{
 			"status_code": statusCode,
@@ -218,127 +570,962 @@ func loggingMiddleware() gin.HandlerFunc {
 	}
 }
 
+// rootHandler describes the service and lists its main endpoints.
+//
+// @Summary Service information
+// @Tags Monitoring
+// @Produce json
+// @Success 200 {object} object
+// @Router / [get]
 func rootHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"service":     "Medical Records Service",
-		"version":     "1.0.0",
+		"version":     version,
+		"git_commit":  gitCommit,
+		"build_time":  buildTime,
+		"go_version":  runtime.Version(),
 		"description": "Healthcare medical records management microservice",
+		"api_versions": gin.H{
+			"v1":      "/api/v1",
+			"default": "/api",
+		},
 		"endpoints": gin.H{
 			"health":     "/health",
+			"version":    "/version",
 			"readiness":  "/ready",
 			"metrics":    "/metrics",
+			"graphql":    "/graphql",
+			"openapi":    "/openapi.json",
 			"records": gin.H{
-				"list":   "GET /api/medical-records",
-				"create": "POST /api/medical-records",
-				"get":    "GET /api/medical-records/{id}",
-				"update": "PUT /api/medical-records/{id}",
-				"delete": "DELETE /api/medical-records/{id}",
+				"list":                 "GET /api/medical-records",
+				"count":                "GET /api/medical-records/count",
+				"search_prescriptions": "GET /api/medical-records/search/prescriptions?medication={name}",
+				"search_medication":    "GET /api/medical-records/search/medication?name={name}&patient_id={id}",
+				"create":               "POST /api/medical-records?strict={true|false}&dry_run={true|false}",
+				"batch_get":            "POST /api/medical-records/batch-get",
+				"clone":                "POST /api/medical-records/{id}/clone",
+				"get":                  "GET /api/medical-records/{id}",
+				"get_by_reference":     "GET /api/medical-records/ref/{reference}",
+				"changes":              "GET /api/medical-records/changes?since={rfc3339}&cursor={cursor}",
+				"update":               "PUT /api/medical-records/{id}?upsert={true|false}",
+				"delete":               "DELETE /api/medical-records/{id}",
+				"history":              "GET /api/medical-records/{id}/history",
+				"revision":             "GET /api/medical-records/{id}/history/{rev}",
+				"upload_attachment":    "POST /api/medical-records/{id}/attachments",
+				"download_attachment":  "GET /api/medical-records/{id}/attachments/{filename}",
+				"verify_attachment":    "GET /api/medical-records/{id}/attachments/{filename}/verify",
+				"add_diagnosis":        "POST /api/medical-records/{id}/diagnoses",
+				"add_prescription":     "POST /api/medical-records/{id}/prescriptions",
+				"update_prescription":  "PATCH /api/medical-records/{id}/prescriptions/{index}",
+				"renew_prescription":   "POST /api/medical-records/{id}/prescriptions/{index}/renew",
+				"add_lab_result":       "POST /api/medical-records/{id}/lab-results",
+				"set_confidential":     "PATCH /api/medical-records/{id}/confidential",
+				"set_consent":          "PUT /api/medical-records/{id}/consent",
+				"lock":                 "POST /api/medical-records/{id}/lock",
+				"unlock":               "DELETE /api/medical-records/{id}/lock",
+				"link":                 "POST /api/medical-records/{id}/links",
+				"unlink":               "DELETE /api/medical-records/{id}/links/{related_id}",
+				"related":              "GET /api/medical-records/{id}/related?fields={fields}",
+			},
+			"patients": gin.H{
+				"summary":    "GET /api/patients/{patient_id}/summary",
+				"timeline":   "GET /api/patients/{patient_id}/timeline?before={date}&limit={n}",
+				"alerts":     "GET /api/patients/{patient_id}/alerts",
+				"merge":      "POST /api/patients/{patient_id}/merge",
+				"erase":      "DELETE /api/patients/{patient_id}/records?hard={true|false}",
+				"export":     "GET /api/patients/{patient_id}/export.zip",
+				"lab_status": "PATCH /api/patients/{patient_id}/lab-results/status",
+			},
+			"appointments": gin.H{
+				"records": "GET /api/appointments/{appointment_id}/records",
+			},
+			"stats": gin.H{
+				"records_by_month": "GET /api/stats/records-by-month?year={year}&doctor_id={id}",
 			},
 		},
-		"timestamp": time.Now().Format(time.RFC3339),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// healthHandler is the liveness probe. It only reports that the process is
+// responsive and deliberately does not check dependencies, so a database
+// outage does not get the pod restarted.
+//
+// @Summary Liveness probe
+// @Tags Monitoring
+// @Produce json
+// @Success 200 {object} object{status=string,service=string,timestamp=string,version=string,git_commit=string,build_time=string}
+// @Router /health [get]
+func healthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "healthy",
+		"service":    "medical-records-service",
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"version":    version,
+		"git_commit": gitCommit,
+		"build_time": buildTime,
+	})
+}
+
+// dependencyCheck is a readiness check against an external dependency.
+// Failing critical dependencies make the service not ready; failing
+// non-critical ones only mark it degraded.
+type dependencyCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// readinessChecks returns the checks for MongoDB and any optional
+// dependencies configured through REDIS_ADDR and KAFKA_BROKERS.
+func readinessChecks() []dependencyCheck {
+	checks := []dependencyCheck{{
+		name:     "mongodb",
+		critical: true,
+		check: func(ctx context.Context) error {
+			if err := db.Client().Ping(ctx, nil); err != nil {
+				return err
+			}
+			return checkRecordsReadable(ctx)
+		},
+	}}
+
+	checks = append(checks, dependencyCheck{
+		name:     "indexes",
+		critical: true,
+		check:    checkRequiredIndexes,
+	})
+
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		checks = append(checks, dependencyCheck{
+			name:  "redis",
+			check: func(ctx context.Context) error { return dialCheck(ctx, addr) },
+		})
+	}
+
+	if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
+		checks = append(checks, dependencyCheck{
+			name: "kafka",
+			check: func(ctx context.Context) error {
+				// Kafka is reachable if any broker accepts connections
+				var err error
+				for _, broker := range strings.Split(brokers, ",") {
+					if err = dialCheck(ctx, strings.TrimSpace(broker)); err == nil {
+						return nil
+					}
+				}
+				return err
+			},
+		})
+	}
+
+	return checks
+}
+
+// checkRecordsReadable counts at most one record, so credentials that can
+// ping the server but not read the records collection fail readiness.
+func checkRecordsReadable(ctx context.Context) error {
+	opts := options.Count().SetLimit(1)
+	if _, err := db.Collection(recordsCollectionName).CountDocuments(ctx, bson.M{}, opts); err != nil {
+		return fmt.Errorf("%s not readable: %w", recordsCollectionName, err)
+	}
+	return nil
+}
+
+// requiredIndexPrefixes are fields that must lead some index on the records
+// collection. Without them listings fall back to collection scans, which
+// typically happens when a collection is restored without its indexes.
+var requiredIndexPrefixes = []string{"patient_id", "created_at"}
+
+// checkRequiredIndexes fails when any required index is missing.
+func checkRequiredIndexes(ctx context.Context) error {
+	cursor, err := db.Collection(recordsCollectionName).Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var indexes []struct {
+		Key bson.D `bson:"key"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	leading := make(map[string]bool)
+	for _, index := range indexes {
+		if len(index.Key) > 0 {
+			leading[index.Key[0].Key] = true
+		}
+	}
+
+	var missing []string
+	for _, field := range requiredIndexPrefixes {
+		if !leading[field] {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing indexes on: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// dialCheck verifies a TCP connection can be opened to addr.
+func dialCheck(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// shuttingDown is set once a shutdown signal arrives, after which the
+// readiness probe fails.
+var shuttingDown atomic.Bool
+
+// readinessHandler is the readiness probe. It reports each dependency's
+// status and an overall ready flag.
+//
+// @Summary Readiness probe
+// @Tags Monitoring
+// @Produce json
+// @Success 200 {object} object{status=string,ready=bool,database=string,dependencies=object}
+// @Failure 503 {object} object{status=string,ready=bool} "Not ready or shutting down"
+// @Router /ready [get]
+func readinessHandler(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down", "ready": false})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ready, degraded := true, false
+	dependencies := gin.H{}
+	for _, dep := range readinessChecks() {
+		if err := dep.check(ctx); err != nil {
+			logger.WithError(err).WithField("dependency", dep.name).Error("Dependency check failed")
+			dependencies[dep.name] = gin.H{"status": "down", "critical": dep.critical, "error": err.Error()}
+			if dep.critical {
+				ready = false
+			} else {
+				degraded = true
+			}
+			continue
+		}
+		dependencies[dep.name] = gin.H{"status": "up", "critical": dep.critical}
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	} else if degraded {
+		status = "degraded"
+	}
+
+	database := "connected"
+	if dependencies["mongodb"].(gin.H)["status"] != "up" {
+		database = "disconnected"
+	}
+
+	c.JSON(code, gin.H{
+		"status":       status,
+		"ready":        ready,
+		"database":     database,
+		"dependencies": dependencies,
+	})
+}
+
+// metricsHandler serves Prometheus metrics. When METRICS_TOKEN is set the
+// scraper must present it as a bearer token; this is independent of the
+// API's own authentication so Prometheus can use a dedicated token.
+//
+// @Summary Prometheus metrics
+// @Tags Monitoring
+// @Produce plain
+// @Success 200 {string} string "Metrics in the Prometheus text format"
+// @Failure 401 {object} apiError "METRICS_TOKEN is set and was not presented"
+// @Router /metrics [get]
+func metricsHandler() gin.HandlerFunc {
+	h := promhttp.Handler()
+	token := os.Getenv("METRICS_TOKEN")
+	return func(c *gin.Context) {
+		if token != "" {
+			provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// loadPageLimits reads the default and maximum page limits from the
+// environment. MAX_PAGE_SIZE and MAX_LIMIT, and DEFAULT_PAGE_SIZE, are
+// accepted as aliases.
+func loadPageLimits() {
+	maxPageLimit = getEnvInt("MAX_PAGE_LIMIT", getEnvInt("MAX_PAGE_SIZE", getEnvInt("MAX_LIMIT", 100)))
+	if maxPageLimit < 1 {
+		logger.Warnf("Invalid max page limit %d, using default 100", maxPageLimit)
+		maxPageLimit = 100
+	}
+	defaultPageLimit = getEnvInt("DEFAULT_PAGE_LIMIT", getEnvInt("DEFAULT_PAGE_SIZE", 10))
+	if defaultPageLimit < 1 || defaultPageLimit > maxPageLimit {
+		logger.Warnf("Invalid DEFAULT_PAGE_LIMIT %d, using %d", defaultPageLimit, min(10, maxPageLimit))
+		defaultPageLimit = min(10, maxPageLimit)
+	}
+}
+
+// parsePagination validates the page and limit query parameters. Page must be
+// at least 1. Limit defaults to defaultPageLimit and is clamped to
+// maxPageLimit; clamped reports whether the requested limit was reduced.
+func parsePagination(c *gin.Context) (page, limit int, clamped bool, err error) {
+	pageNum, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || pageNum < 1 {
+		return 0, 0, false, fmt.Errorf("page must be a positive integer")
+	}
+
+	limitNum := defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		limitNum, err = strconv.Atoi(value)
+		if err != nil || limitNum < 1 {
+			return 0, 0, false, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	if limitNum > maxPageLimit {
+		limitNum, clamped = maxPageLimit, true
+	}
+
+	// Reject pages so large that the skip offset would overflow
+	if pageNum-1 > math.MaxInt32/limitNum {
+		return 0, 0, false, fmt.Errorf("page is too large")
+	}
+
+	return pageNum, limitNum, clamped, nil
+}
+
+// pageURL returns the absolute URL of the current request with its page
+// parameter set to page, keeping every other query parameter.
+func pageURL(c *gin.Context, page int) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	query := c.Request.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	u := url.URL{Scheme: scheme, Host: c.Request.Host, Path: c.Request.URL.Path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// addPageLinks adds next and prev URLs to a paginated response, omitting
+// each when there is no such page.
+func addPageLinks(c *gin.Context, response gin.H, page, totalPages int) {
+	if page < totalPages {
+		response["next"] = pageURL(c, page+1)
+	}
+	if page > 1 {
+		response["prev"] = pageURL(c, min(page-1, max(totalPages, 1)))
+	}
+}
+
+// sortableFields lists the record fields clients may sort listings by.
+var sortableFields = map[string]bool{
+	"created_at":  true,
+	"updated_at":  true,
+	"title":       true,
+	"record_type": true,
+}
+
+// parseSort converts a sort query value such as "-created_at" into sort
+// fields. A leading "-" means descending order. _id breaks ties in the same
+// direction, so records sharing a value, such as a bulk import's
+// created_at, page in a stable order without repeats or gaps.
+func parseSort(value string) ([]SortField, error) {
+	if value == "" {
+		return []SortField{{Field: "created_at", Descending: true}, {Field: "_id", Descending: true}}, nil
+	}
+
+	field, descending := value, false
+	if strings.HasPrefix(value, "-") {
+		field, descending = value[1:], true
+	}
+
+	if !sortableFields[field] {
+		return nil, fmt.Errorf("invalid sort field: %s", field)
+	}
+
+	return []SortField{{Field: field, Descending: descending}, {Field: "_id", Descending: descending}}, nil
+}
+
+// recordFields holds the bson field names of MedicalRecord and is used to
+// validate client-supplied projections.
+var recordFields = bsonFieldNames(reflect.TypeOf(MedicalRecord{}))
+
+// alwaysProjectedFields are included in every projection regardless of the
+// fields requested.
+var alwaysProjectedFields = []string{"_id", "patient_id", "record_type"}
+
+// summaryFields is the default projection used for listings when no fields
+// parameter is supplied.
+var summaryFields = []string{"doctor_id", "appointment_id", "title", "is_confidential", "created_at", "updated_at"}
+
+func bsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseProjection lists the record fields to load from a comma-separated
+// fields query value. _id, patient_id and record_type are always included.
+func parseProjection(value string) ([]string, error) {
+	fields := summaryFields
+	if value != "" {
+		fields = strings.Split(value, ",")
+	}
+
+	projection := slices.Clone(alwaysProjectedFields)
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || slices.Contains(projection, field) {
+			continue
+		}
+		if !recordFields[field] {
+			return nil, fmt.Errorf("invalid field: %s", field)
+		}
+		projection = append(projection, field)
+	}
+
+	return projection, nil
+}
+
+// validRecordTypes is the record_type allowlist, taken from the oneof rule on
+// MedicalRecord.RecordType so filters and struct validation stay in sync.
+var validRecordTypes = oneofValues(reflect.TypeOf(MedicalRecord{}), "RecordType")
+
+// validDiagnosisSeverities is the diagnosis_severity allowlist, likewise
+// taken from Diagnosis.Severity.
+var validDiagnosisSeverities = oneofValues(reflect.TypeOf(Diagnosis{}), "Severity")
+
+// oneofValues returns the allowed values of a oneof validate rule on the
+// named struct field.
+func oneofValues(t reflect.Type, fieldName string) map[string]bool {
+	values := make(map[string]bool)
+	field, ok := t.FieldByName(fieldName)
+	if !ok {
+		return values
+	}
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		if strings.HasPrefix(rule, "oneof=") {
+			for _, value := range strings.Fields(strings.TrimPrefix(rule, "oneof=")) {
+				values[value] = true
+			}
+		}
+	}
+	return values
+}
+
+// parseRecordTypes splits a comma-separated record_type value, dropping
+// duplicates and rejecting unknown types.
+func parseRecordTypes(value string) ([]string, error) {
+	return parseValueList("record_type", value, validRecordTypes)
+}
+
+// parseValueList splits the comma-separated value of the param query
+// parameter, dropping duplicates and rejecting values not in allowed.
+func parseValueList(param, value string, allowed map[string]bool) ([]string, error) {
+	var values []string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		if !allowed[item] {
+			return nil, fmt.Errorf("invalid %s: %s", param, item)
+		}
+		seen[item] = true
+		values = append(values, item)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("invalid %s: %s", param, value)
+	}
+	return values, nil
+}
+
+// parseDateParam parses a date query value given either as RFC3339 or as a
+// plain YYYY-MM-DD date.
+func parseDateParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// maxQueryLength caps the free-text q parameter.
+const maxQueryLength = 100
+
+// buildRecordFilter builds the filter shared by the list and count
+// endpoints from the patient_id, record_type, diagnosis_code,
+// diagnosis_severity, q, date_from and date_to query parameters. Dates
+// bound created_at inclusively. diagnosis_code and diagnosis_severity given
+// together must match the same diagnosis. q matches title, description or
+// diagnosis description case-insensitively, and is combined with the other
+// parameters.
+func buildRecordFilter(c *gin.Context) (RecordFilter, error) {
+	filter := RecordFilter{
+		PatientID:     normalizeID(c.Query("patient_id")),
+		DiagnosisCode: c.Query("diagnosis_code"),
+	}
+	if recordType := c.Query("record_type"); recordType != "" {
+		types, err := parseRecordTypes(recordType)
+		if err != nil {
+			return RecordFilter{}, err
+		}
+		filter.RecordTypes = types
+	}
+	if severity := c.Query("diagnosis_severity"); severity != "" {
+		severities, err := parseValueList("diagnosis_severity", severity, validDiagnosisSeverities)
+		if err != nil {
+			return RecordFilter{}, err
+		}
+		filter.DiagnosisSeverities = severities
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		if len(q) > maxQueryLength {
+			return RecordFilter{}, fmt.Errorf("q must be at most %d characters", maxQueryLength)
+		}
+		filter.Text = q
+	}
+
+	if dateFrom := c.Query("date_from"); dateFrom != "" {
+		from, err := parseDateParam(dateFrom)
+		if err != nil {
+			return RecordFilter{}, fmt.Errorf("invalid date_from: %s", dateFrom)
+		}
+		filter.CreatedFrom = from
+	}
+	if dateTo := c.Query("date_to"); dateTo != "" {
+		to, err := parseDateParam(dateTo)
+		if err != nil {
+			return RecordFilter{}, fmt.Errorf("invalid date_to: %s", dateTo)
+		}
+		filter.CreatedTo = to
+	}
+
+	return filter, nil
+}
+
+// getMedicalRecords lists the records matching the query filters a page at
+// a time.
+//
+// @Summary List records
+// @Tags Records
+// @Produce json
+// @Param patient_id query string false "Only records of this patient"
+// @Param record_type query string false "Record type, or several separated by commas"
+// @Param diagnosis_code query string false "Only records with this diagnosis code"
+// @Param diagnosis_severity query string false "Diagnosis severity, or several separated by commas"
+// @Param q query string false "Text matched against title, description and diagnosis descriptions" maxlength(100)
+// @Param date_from query string false "Created at or after this date (RFC 3339 or YYYY-MM-DD)"
+// @Param date_to query string false "Created at or before this date (RFC 3339 or YYYY-MM-DD)"
+// @Param page query int false "Page number, starting at 1" minimum(1) default(1)
+// @Param limit query int false "Records per page, capped at the configured maximum" minimum(1)
+// @Param sort query string false "Sort field, prefixed with - for descending order" Enums(created_at, -created_at, updated_at, -updated_at, title, -title, record_type, -record_type)
+// @Param fields query string false "Comma-separated record fields to return"
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Success 200 {object} recordPage
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/medical-records [get]
+func getMedicalRecords(c *gin.Context) {
+	filter, err := buildRecordFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	listRecords(c, filter)
+}
+
+// countMedicalRecords counts the records matching the same filters as
+// getMedicalRecords.
+//
+// @Summary Count records
+// @Tags Records
+// @Produce json
+// @Param patient_id query string false "Only records of this patient"
+// @Param record_type query string false "Record type, or several separated by commas"
+// @Param diagnosis_code query string false "Only records with this diagnosis code"
+// @Param diagnosis_severity query string false "Diagnosis severity, or several separated by commas"
+// @Param q query string false "Text matched against title, description and diagnosis descriptions" maxlength(100)
+// @Param date_from query string false "Created at or after this date (RFC 3339 or YYYY-MM-DD)"
+// @Param date_to query string false "Created at or before this date (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {object} object{count=int}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/count [get]
+func countMedicalRecords(c *gin.Context) {
+	filter, err := buildRecordFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	count, err := recordStore.Count(ctx, filter)
+	if err != nil {
+		logger.WithError(err).Error("Failed to count medical records")
+		respondQueryError(c, err, "Failed to count records")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+// getAppointmentRecords lists the records of an appointment a page at a
+// time.
+//
+// @Summary Records of an appointment
+// @Tags Appointments
+// @Produce json
+// @Param appointment_id path string true "Appointment ID"
+// @Param page query int false "Page number, starting at 1" minimum(1) default(1)
+// @Param limit query int false "Records per page, capped at the configured maximum" minimum(1)
+// @Param sort query string false "Sort field, prefixed with - for descending order" Enums(created_at, -created_at, updated_at, -updated_at, title, -title, record_type, -record_type)
+// @Param fields query string false "Comma-separated record fields to return"
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Success 200 {object} recordPage
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/appointments/{appointment_id}/records [get]
+func getAppointmentRecords(c *gin.Context) {
+	appointmentID := c.Param("appointment_id")
+	if appointmentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Appointment ID is required"})
+		return
+	}
+
+	listRecords(c, RecordFilter{AppointmentID: appointmentID})
+}
+
+// listRecords writes a paginated, sorted page of records matching filter
+// using the standard list response envelope. The fields query parameter
+// selects which record fields are returned; _id, patient_id and record_type
+// are always included and a summary projection is used when it is absent.
+// Listings use the analytics read preference, so on secondaries they may
+// briefly lag writes.
+func listRecords(c *gin.Context, filter RecordFilter) {
+	pageNum, limitNum, clamped, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	skip := (pageNum - 1) * limitNum
+
+	sort, err := parseSort(c.Query("sort"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projection, err := parseProjection(c.Query("fields"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	// Get total count
+	total, err := recordStore.Count(ctx, filter)
+	if err != nil {
+		logger.WithError(err).Error("Failed to count medical records")
+		respondQueryError(c, err, "Failed to count records")
+		return
+	}
+
+	// Get records with pagination
+	records, err := recordStore.Search(ctx, RecordQuery{
+		RecordFilter: filter,
+		Sort:         sort,
+		Fields:       projection,
+		Skip:         skip,
+		Limit:        limitNum,
 	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch medical records")
+		respondQueryError(c, err, "Failed to fetch records")
+		return
+	}
+	localizeRecords(records, loc)
+
+	totalPages := (int(total) + limitNum - 1) / limitNum
+
+	response := gin.H{
+		"records":       records,
+		"total":         total,
+		"page":          pageNum,
+		"limit":         limitNum,
+		"limit_clamped": clamped,
+		"total_pages":   totalPages,
+		"has_next":      pageNum < totalPages,
+		"has_previous":  pageNum > 1,
+	}
+	addPageLinks(c, response, pageNum, totalPages)
+	c.JSON(http.StatusOK, response)
 }
 
-func healthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":    "healthy",
-		"service":   "medical-records-service",
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   "1.0.0",
-	})
-}
+// searchPrescriptions finds records prescribing a medication, matched
+// case-insensitively against prescriptions.medication_name. By default the
+// name may appear anywhere; match=prefix anchors it to the start. Each
+// returned record only carries the matching prescription entries. Like the
+// summary it uses the analytics read preference and may lag recent writes.
+// It is also served as /search/medication?name=, and patient_id scopes the
+// search to one patient.
+//
+// @Summary Find records prescribing a medication
+// @Tags Records
+// @Produce json
+// @Param medication query string false "Medication name; required on /search/prescriptions"
+// @Param name query string false "Medication name; required on /search/medication"
+// @Param match query string false "How the name is matched" Enums(partial, prefix) default(partial)
+// @Param patient_id query string false "Only records of this patient"
+// @Param page query int false "Page number, starting at 1" minimum(1) default(1)
+// @Param limit query int false "Records per page, capped at the configured maximum" minimum(1)
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Success 200 {object} recordPage
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/search/prescriptions [get]
+// @Router /api/v1/medical-records/search/medication [get]
+func searchPrescriptions(c *gin.Context) {
+	medication := strings.TrimSpace(c.Query("medication"))
+	if medication == "" {
+		medication = strings.TrimSpace(c.Query("name"))
+	}
+	if medication == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "medication is required"})
+		return
+	}
+
+	query := PrescriptionQuery{Medication: medication}
+	switch c.DefaultQuery("match", "partial") {
+	case "partial":
+	case "prefix":
+		query.Prefix = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "match must be one of: partial, prefix"})
+		return
+	}
+
+	var warnings []RecordWarning
+	query.PatientID = normalizeID(c.Query("patient_id"))
+	if query.PatientID == "" && !crossPatientSearchRoles[c.GetString(contextRole)] {
+		// Not enforced yet so existing integrations keep working
+		logger.WithField("user_id", currentUser(c)).Warn("Cross-patient medication search without an elevated role")
+		warnings = append(warnings, RecordWarning{
+			Type:     "unscoped_search",
+			Message:  "Searching across all patients should be scoped with patient_id or done with an elevated role",
+			Severity: "medium",
+		})
+	}
+
+	pageNum, limitNum, clamped, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-func readinessHandler(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := dbReadContext(c)
 	defer cancel()
 
-	// Test database connection
-	if err := db.Client().Ping(ctx, nil); err != nil {
-		logger.WithError(err).Error("Database ping failed")
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status":   "not ready",
-			"database": "disconnected",
-			"error":    err.Error(),
-		})
+	query.Skip = (pageNum - 1) * limitNum
+	query.Limit = limitNum
+	records, total, err := recordStore.SearchPrescriptions(ctx, query)
+	if err != nil {
+		logger.WithError(err).Error("Failed to search prescriptions")
+		respondQueryError(c, err, "Failed to search prescriptions")
 		return
 	}
+	localizeRecords(records, loc)
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":   "ready",
-		"database": "connected",
-	})
-}
+	totalPages := (int(total) + limitNum - 1) / limitNum
 
-func metricsHandler() gin.HandlerFunc {
-	h := promhttp.Handler()
-	return func(c *gin.Context) {
-		h.ServeHTTP(c.Writer, c.Request)
+	response := gin.H{
+		"records":       records,
+		"total":         total,
+		"page":          pageNum,
+		"limit":         limitNum,
+		"limit_clamped": clamped,
+		"total_pages":   totalPages,
+		"has_next":      pageNum < totalPages,
+		"has_previous":  pageNum > 1,
+	}
+	addPageLinks(c, response, pageNum, totalPages)
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
 	}
+	c.JSON(http.StatusOK, response)
 }
 
-func getMedicalRecords(c *gin.Context) {
-	patientID := c.Query("patient_id")
-	recordType := c.Query("record_type")
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "10")
+// crossPatientSearchRoles may search medications across all patients
+// without a warning.
+var crossPatientSearchRoles = map[string]bool{
+	"admin":      true,
+	"pharmacist": true,
+}
 
-	pageNum, _ := strconv.Atoi(page)
-	limitNum, _ := strconv.Atoi(limit)
-	skip := (pageNum - 1) * limitNum
+type batchGetRequest struct {
+	IDs []string `json:"ids" binding:"required"`
+}
 
-	filter := bson.M{}
-	if patientID != "" {
-		filter["patient_id"] = patientID
+// batchGetMedicalRecords fetches several records in one query. Records are
+// returned in the order requested and IDs with no record are listed in
+// missing; not_found carries the same list for older clients.
+//
+// @Summary Get several records by ID
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param request body object{ids=[]string} true "Record IDs"
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Success 200 {object} object{records=[]MedicalRecord,missing=[]string,not_found=[]string}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/batch-get [post]
+func batchGetMedicalRecords(c *gin.Context) {
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	if recordType != "" {
-		filter["record_type"] = recordType
+
+	var req batchGetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must not be empty"})
+		return
+	}
+	if len(req.IDs) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d ids may be requested", maxBatchSize)})
+		return
+	}
 
-	// Get total count
-	total, err := db.Collection("medical_records").CountDocuments(ctx, filter)
-	if err != nil {
-		logger.WithError(err).Error("Failed to count medical records")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count records"})
+	objectIDs := make([]primitive.ObjectID, 0, len(req.IDs))
+	var invalid []string
+	for _, id := range req.IDs {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			invalid = append(invalid, id)
+			continue
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+	if len(invalid) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID", "invalid_ids": invalid})
 		return
 	}
 
-	// Get records with pagination
-	options := options.Find().
-		SetSort(bson.D{{Key: "created_at", Value: -1}}).
-		SetSkip(int64(skip)).
-		SetLimit(int64(limitNum))
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
 
-	cursor, err := db.Collection("medical_records").Find(ctx, filter, options)
+	found, err := findRecords(ctx, RecordQuery{RecordFilter: RecordFilter{IDs: objectIDs}})
 	if err != nil {
 		logger.WithError(err).Error("Failed to fetch medical records")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch records"})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var records []MedicalRecord
-	if err := cursor.All(ctx, &records); err != nil {
-		logger.WithError(err).Error("Failed to decode medical records")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode records"})
-		return
+	localizeRecords(found, loc)
+	byID := make(map[primitive.ObjectID]MedicalRecord, len(found))
+	for _, record := range found {
+		byID[record.ID] = record
 	}
 
-	totalPages := (int(total) + limitNum - 1) / limitNum
+	records := []MedicalRecord{}
+	notFound := []string{}
+	for i, objectID := range objectIDs {
+		if record, ok := byID[objectID]; ok {
+			records = append(records, record)
+		} else {
+			notFound = append(notFound, req.IDs[i])
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"records":      records,
-		"total":        total,
-		"page":         pageNum,
-		"limit":        limitNum,
-		"total_pages":  totalPages,
-		"has_next":     pageNum < totalPages,
-		"has_previous": pageNum > 1,
+		"records":   records,
+		"missing":   notFound,
+		"not_found": notFound,
 	})
 }
 
+// getMedicalRecord returns a single record with an ETag and Last-Modified
+// and honours If-None-Match and If-Modified-Since so clients can revalidate
+// cached copies cheaply.
+//
+// @Summary Get a record
+// @Tags Records
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of a cached copy; ignored when If-None-Match is sent"
+// @Success 200 {object} MedicalRecord
+// @Header 200 {string} ETag "Identifies this version of the record"
+// @Header 200 {string} Last-Modified "When the record was last updated"
+// @Success 304 "Not modified since the given ETag or date"
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id} [get]
 func getMedicalRecord(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -346,14 +1533,18 @@ func getMedicalRecord(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
 		return
 	}
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := dbReadContext(c)
 	defer cancel()
 
-	var record MedicalRecord
-	err = db.Collection("medical_records").FindOne(ctx, bson.M{"_id": objectID}).Decode(&record)
+	record, err := findRecord(ctx, objectID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
 			return
 		}
@@ -362,39 +1553,320 @@ func getMedicalRecord(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, record)
+	localizeRecord(&record, loc)
+	respondWithETag(c, record)
+}
+
+// fieldError describes one field that failed validation.
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// validationReason describes in plain words why fe failed, such as
+// "must be one of [mild moderate severe critical]".
+func validationReason(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return fmt.Sprintf("must be one of [%s]", fe.Param())
+	case "min", "gte":
+		return "must be at least " + fe.Param()
+	case "max", "lte":
+		return "must be at most " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "lt":
+		return "must be less than " + fe.Param()
+	case "len":
+		return "must have length " + fe.Param()
+	case "email":
+		return "must be a valid email address"
+	}
+	if fe.Param() != "" {
+		return fmt.Sprintf("failed on the '%s=%s' rule", fe.Tag(), fe.Param())
+	}
+	return fmt.Sprintf("failed on the '%s' rule", fe.Tag())
+}
+
+// validationFieldErrors lists each field in errs by its JSON path, such as
+// "diagnosis[0].severity", with the reason it failed.
+func validationFieldErrors(errs validator.ValidationErrors) []fieldError {
+	details := make([]fieldError, 0, len(errs))
+	for _, fe := range errs {
+		// Drop the leading struct name: "MedicalRecord.diagnosis[0].code"
+		field := fe.Namespace()
+		if _, rest, ok := strings.Cut(field, "."); ok {
+			field = rest
+		}
+		details = append(details, fieldError{Field: field, Rule: fe.Tag(), Message: validationReason(fe)})
+	}
+	return details
+}
+
+// respondValidationError writes a 422 for a payload that parsed but failed
+// validation. details lists each offending field so clients can highlight
+// them, and fields maps each field path to its reason for simple lookups.
+func respondValidationError(c *gin.Context, err error) {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	details := validationFieldErrors(validationErrs)
+	fields := make(map[string]string, len(details))
+	for _, detail := range details {
+		fields[detail.Field] = detail.Message
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":   "Validation failed",
+		"details": details,
+		"fields":  fields,
+	})
+}
+
+// respondVitalRangeError writes a 422 naming the out-of-range vital sign.
+func respondVitalRangeError(c *gin.Context, err error) {
+	rangeErr, ok := err.(*VitalRangeError)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error": rangeErr.Error(),
+		"field": "vital_signs." + rangeErr.Field,
+		"value": rangeErr.Value,
+	})
+}
+
+// createRecordResponse is the created record plus any non-blocking
+// warnings raised while creating it.
+type createRecordResponse struct {
+	MedicalRecord
+	Warnings []RecordWarning `json:"warnings,omitempty"`
+	DryRun   bool            `json:"dry_run,omitempty"`
 }
 
+// dryRunResponse is a createRecordResponse without an ID, since a dry run
+// stores nothing. Its nil ID hides the record's zero ID when encoded.
+type dryRunResponse struct {
+	createRecordResponse
+	ID *primitive.ObjectID `json:"id,omitempty"`
+}
+
+// createMedicalRecord stores a new record. Missing recommended fields are
+// returned as warnings, or rejected with 422 when strict=true. With
+// dry_run=true or an X-Dry-Run: true header the record is validated and
+// returned with its computed fields but not stored.
+//
+// @Summary Create a record
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param record body MedicalRecord true "The record"
+// @Param strict query bool false "Reject records missing recommended fields" default(false)
+// @Param dry_run query bool false "Validate and return the record without storing it" default(false)
+// @Param X-Dry-Run header bool false "Same as dry_run"
+// @Param Idempotency-Key header string false "Replays the original record when a request is retried with the same key"
+// @Success 201 {object} createRecordResponse
+// @Success 200 {object} dryRunResponse "Validated only (dry run)"
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 409 {object} apiError "A record of this type already exists for the appointment"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records [post]
 func createMedicalRecord(c *gin.Context) {
+	strict, err := strconv.ParseBool(c.DefaultQuery("strict", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "strict must be true or false"})
+		return
+	}
+	dryRunValue := c.Query("dry_run")
+	if dryRunValue == "" {
+		dryRunValue = c.GetHeader("X-Dry-Run")
+	}
+	dryRun := false
+	if dryRunValue != "" {
+		if dryRun, err = strconv.ParseBool(dryRunValue); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+			return
+		}
+	}
+
 	var record MedicalRecord
 	if err := c.ShouldBindJSON(&record); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
 	if err := validate.Struct(&record); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	record.ID = primitive.NewObjectID()
-	record.CreatedAt = time.Now()
-	record.UpdatedAt = time.Now()
+	if err := validateVitalSigns(record.VitalSigns); err != nil {
+		respondVitalRangeError(c, err)
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := validateAttachments(record.Attachments); err != nil {
+		respondAttachmentError(c, err)
+		return
+	}
+	record.Attachments = withStoredAttachmentFields(record.Attachments, nil)
+
+	if err := normalizeRecordIDs(&record); err != nil {
+		respondIDFormatError(c, err)
+		return
+	}
+
+	normalizeConsent(record.Consent, time.Now().UTC())
+	if err := checkConsent(record.IsConfidential, record.Consent); err != nil {
+		respondConsentRequired(c)
+		return
+	}
+
+	completeness := checkRecordCompleteness(&record)
+	if strict && len(completeness) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":    "Record is missing recommended fields",
+			"warnings": completeness,
+		})
+		return
+	}
+
+	record.ID = primitive.NewObjectID()
+	record.OrganizationID = currentOrganization(c)
+	// Links are only made through the links endpoint, which checks them
+	record.RelatedRecordIDs = nil
+	record.CreatedAt = time.Now().UTC()
+	record.UpdatedAt = time.Now().UTC()
+	normalizeRecordTimes(&record)
+	markExpiredPrescriptions(record.Prescriptions, record.UpdatedAt)
+	computeBMI(record.VitalSigns)
+	typeLabResults(record.LabResults)
+
+	ctx, cancel := dbWriteContext(c)
 	defer cancel()
 
-	_, err := db.Collection("medical_records").InsertOne(ctx, record)
+	// Flag potential drug interactions without blocking the create
+	warnings, err := checkDrugInteractions(ctx, &record)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to check drug interactions")
+	}
+	warnings = append(warnings, completeness...)
+
+	sizeWarnings, err := checkRecordSize(record)
+	var sizeErr *DocumentSizeError
+	if errors.As(err, &sizeErr) {
+		respondDocumentTooLarge(c, sizeErr)
+		return
+	}
+	warnings = append(warnings, sizeWarnings...)
+
+	if dryRun {
+		// Nothing was stored, so there is no ID to hand out
+		record.ID = primitive.NilObjectID
+		c.JSON(http.StatusOK, dryRunResponse{
+			createRecordResponse: createRecordResponse{MedicalRecord: record, Warnings: warnings, DryRun: true},
+		})
+		return
+	}
+
+	// Replay the original record when a retried request reuses its key
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey != "" {
+		recordID, claimed, err := claimIdempotencyKey(ctx, idempotencyKey, record.ID)
+		if err != nil {
+			logger.WithError(err).Error("Failed to check idempotency key")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create record"})
+			return
+		}
+		if !claimed {
+			existing, err := recordStore.Get(ctx, recordID)
+			if err != nil {
+				if errors.Is(err, ErrRecordNotFound) {
+					c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+					return
+				}
+				logger.WithError(err).Error("Failed to fetch idempotent medical record")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch record"})
+				return
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.JSON(http.StatusCreated, existing)
+			return
+		}
+	}
+
+	// Numbered outside the transaction so concurrent creates do not
+	// conflict on the counter document
+	record.ReferenceNumber, err = nextReferenceNumber(ctx, record.CreatedAt)
+	if err != nil {
+		if idempotencyKey != "" {
+			releaseIdempotencyKey(ctx, idempotencyKey)
+		}
+		logger.WithError(err).Error("Failed to generate reference number")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create record"})
+		return
+	}
+
+	// The idempotency claim stays outside the transaction since it guards
+	// against concurrent retries; it is released if the insert fails.
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		return recordStore.Create(ctx, &record)
+	})
 	if err != nil {
+		if idempotencyKey != "" {
+			releaseIdempotencyKey(ctx, idempotencyKey)
+		}
+		if errors.Is(err, ErrDuplicateRecord) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A record of this type already exists for this appointment"})
+			return
+		}
 		logger.WithError(err).Error("Failed to create medical record")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create record"})
 		return
 	}
 
 	logger.WithField("record_id", record.ID.Hex()).Info("Medical record created successfully")
-	c.JSON(http.StatusCreated, record)
+	notifyCriticalLabResults(record, detectCriticalResults(record))
+	c.JSON(http.StatusCreated, createRecordResponse{MedicalRecord: record, Warnings: warnings})
 }
 
+// updateMedicalRecord replaces a record's fields. Nested fields (diagnosis,
+// prescriptions, lab_results, attachments, vital_signs) left out of the body
+// keep their stored value; sending them, even as [] or null, replaces them.
+// With upsert=true a missing record is created under the given ID instead
+// of returning 404, for clients that sync records created offline; 201
+// reports a create.
+//
+// @Summary Update a record
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param upsert query bool false "Create the record if it does not exist" default(false)
+// @Param record body MedicalRecord true "The record"
+// @Success 200 {object} createRecordResponse
+// @Success 201 {object} createRecordResponse "Created by upsert"
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 409 {object} apiError "A record of this type already exists for the appointment"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id} [put]
 func updateMedicalRecord(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -403,43 +1875,260 @@ func updateMedicalRecord(c *gin.Context) {
 		return
 	}
 
+	upsert, err := strconv.ParseBool(c.DefaultQuery("upsert", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "upsert must be true or false"})
+		return
+	}
+
 	var updateData MedicalRecord
-	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindBodyWith(&updateData, binding.JSON); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	sent, err := sentJSONFields(c)
+	if err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	// The body's top-level fields replace the stored ones, so they must be
+	// valid as sent. Nested fields left out keep their stored value and are
+	// not checked, except on an upsert, which may insert the body as is.
+	var unsent []string
+	if !upsert {
+		for field, name := range map[string]string{
+			"diagnosis":     "Diagnosis",
+			"prescriptions": "Prescriptions",
+			"lab_results":   "LabResults",
+			"attachments":   "Attachments",
+			"vital_signs":   "VitalSigns",
+			"consent":       "Consent",
+		} {
+			if !sent[field] {
+				unsent = append(unsent, name)
+			}
+		}
+	}
+	if err := validate.StructExcept(&updateData, unsent...); err != nil {
+		respondValidationError(c, err)
 		return
 	}
 
-	updateData.UpdatedAt = time.Now()
+	if err := validateVitalSigns(updateData.VitalSigns); err != nil {
+		respondVitalRangeError(c, err)
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := validateAttachments(updateData.Attachments); err != nil {
+		respondAttachmentError(c, err)
+		return
+	}
+	updateData.Attachments = withStoredAttachmentFields(updateData.Attachments, nil)
+
+	if err := normalizeRecordIDs(&updateData); err != nil {
+		respondIDFormatError(c, err)
+		return
+	}
+
+	// Diagnoses not in the body are left as stored, so only sent ones are checked
+	var warnings []RecordWarning
+	if sent["diagnosis"] {
+		var dupErr *DuplicateDiagnosisError
+		warnings, err = checkDuplicateDiagnoses(duplicateDiagnosisCodes(updateData.Diagnosis))
+		if errors.As(err, &dupErr) {
+			respondDuplicateDiagnosis(c, dupErr)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	updateData.UpdatedAt = now
+	normalizeRecordTimes(&updateData)
+	normalizeConsent(updateData.Consent, now)
+	computeBMI(updateData.VitalSigns)
+	typeLabResults(updateData.LabResults)
+	markExpiredPrescriptions(updateData.Prescriptions, now)
+
+	createdBy := currentUser(c)
+	if createdBy == "" {
+		createdBy = updateData.CreatedBy
+	}
+
+	ctx, cancel := dbWriteContext(c)
 	defer cancel()
 
-	update := bson.M{"$set": updateData}
-	result, err := db.Collection("medical_records").UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	// A record an upsert will create is numbered outside the transaction,
+	// as creates are, so concurrent writes do not conflict on the counter
+	// document
+	var reference string
+	if upsert {
+		_, err := findRecord(ctx, objectID)
+		if errors.Is(err, ErrRecordNotFound) {
+			reference, err = nextReferenceNumber(ctx, now)
+		}
+		if err != nil {
+			logger.WithError(err).Error("Failed to prepare medical record upsert")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update record"})
+			return
+		}
+	}
+
+	// Snapshot the current state and apply the update atomically
+	var previous, updatedRecord MedicalRecord
+	var created bool
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		// Reset for retries of the transaction
+		previous, created = MedicalRecord{}, false
+
+		var err error
+		previous, updatedRecord, err = recordStore.Update(ctx, objectID, func(record *MedicalRecord) error {
+			if err := checkRecordLock(*record, currentUser(c)); err != nil {
+				return err
+			}
+			merged := mergeRecordUpdate(*record, updateData, sent)
+			if err := checkConsent(merged.IsConfidential, merged.Consent); err != nil {
+				return err
+			}
+			*record = merged
+			return nil
+		})
+		if err == nil {
+			// Only once stored, so a refused update leaves no revision
+			return saveRevision(ctx, previous)
+		}
+		if !errors.Is(err, ErrRecordNotFound) || !upsert {
+			return err
+		}
+
+		created = true
+		if err := checkConsent(updateData.IsConfidential, updateData.Consent); err != nil {
+			return err
+		}
+		// Deleted since the check above
+		if reference == "" {
+			if reference, err = nextReferenceNumber(ctx, now); err != nil {
+				return err
+			}
+		}
+		updatedRecord = updateData
+		updatedRecord.ID = objectID
+		updatedRecord.ReferenceNumber = reference
+		updatedRecord.CreatedAt = now
+		updatedRecord.CreatedBy = createdBy
+		updatedRecord.RelatedRecordIDs = nil
+		updatedRecord.LockedBy, updatedRecord.LockExpiresAt = "", nil
+		return recordStore.Create(ctx, &updatedRecord)
+	})
 	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+			return
+		}
+		if errors.Is(err, ErrDuplicateRecord) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A record of this type already exists for this appointment"})
+			return
+		}
+		var lockErr *RecordLockedError
+		if errors.As(err, &lockErr) {
+			respondRecordLocked(c, lockErr)
+			return
+		}
+		if errors.Is(err, errConsentRequired) {
+			respondConsentRequired(c)
+			return
+		}
 		logger.WithError(err).Error("Failed to update medical record")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update record"})
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
-		return
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		logger.WithField("record_id", id).Info("Medical record created by upsert")
+	} else {
+		logger.WithField("record_id", id).Info("Medical record updated successfully")
 	}
 
-	logger.WithField("record_id", id).Info("Medical record updated successfully")
+	// Already stored, so a record near the size limit is only flagged
+	if sizeWarnings, err := checkRecordSize(updatedRecord); err == nil {
+		warnings = append(warnings, sizeWarnings...)
+	}
 
-	// Fetch and return the updated record
-	var updatedRecord MedicalRecord
-	err = db.Collection("medical_records").FindOne(ctx, bson.M{"_id": objectID}).Decode(&updatedRecord)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated record"})
-		return
+	notifyCriticalLabResults(updatedRecord, newCriticalResults(previous, updatedRecord))
+	c.JSON(status, createRecordResponse{MedicalRecord: updatedRecord, Warnings: warnings})
+}
+
+// sentJSONFields returns the top-level keys present in a JSON body already
+// read with ShouldBindBodyWith. It is how an absent array is told apart
+// from one sent empty, which decode to the same Go value.
+func sentJSONFields(c *gin.Context) (map[string]bool, error) {
+	body, _ := c.Get(gin.BodyBytesKey)
+	data, _ := body.([]byte)
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
 	}
+	sent := make(map[string]bool, len(raw))
+	for key := range raw {
+		sent[key] = true
+	}
+	return sent, nil
+}
 
-	c.JSON(http.StatusOK, updatedRecord)
+// mergeRecordUpdate returns current with the fields of a full update. The
+// ID, reference number and creation fields are kept so an update never
+// overwrites them. Lock fields and links are only changed through their
+// own endpoints, and nested fields (diagnosis, prescriptions, lab_results,
+// attachments, vital_signs, consent) not in sent keep their stored value.
+func mergeRecordUpdate(current, update MedicalRecord, sent map[string]bool) MedicalRecord {
+	merged := update
+	merged.ID = current.ID
+	merged.OrganizationID = current.OrganizationID
+	merged.ReferenceNumber = current.ReferenceNumber
+	merged.CreatedAt, merged.CreatedBy = current.CreatedAt, current.CreatedBy
+	merged.RelatedRecordIDs = current.RelatedRecordIDs
+	merged.LockedBy, merged.LockExpiresAt = current.LockedBy, current.LockExpiresAt
+
+	if !sent["diagnosis"] {
+		merged.Diagnosis = current.Diagnosis
+	}
+	if !sent["prescriptions"] {
+		merged.Prescriptions = current.Prescriptions
+	}
+	if !sent["lab_results"] {
+		merged.LabResults = current.LabResults
+	}
+	if !sent["vital_signs"] {
+		merged.VitalSigns = current.VitalSigns
+	}
+	if !sent["consent"] {
+		merged.Consent = current.Consent
+	}
+	if sent["attachments"] {
+		merged.Attachments = withStoredAttachmentFields(update.Attachments, current.Attachments)
+	} else {
+		merged.Attachments = current.Attachments
+	}
+	return merged
 }
 
+// deleteMedicalRecord deletes a record and leaves a tombstone for sync
+// clients.
+//
+// @Summary Delete a record
+// @Tags Records
+// @Produce json
+// @Param id path string true "Record ID"
+// @Success 204 "Deleted"
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id} [delete]
 func deleteMedicalRecord(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -448,70 +2137,104 @@ func deleteMedicalRecord(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := dbWriteContext(c)
 	defer cancel()
 
-	result, err := db.Collection("medical_records").DeleteOne(ctx, bson.M{"_id": objectID})
+	// The tombstone commits with the delete so sync clients always learn of it
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		deleted, err := recordStore.Delete(ctx, objectID)
+		if err != nil {
+			return err
+		}
+		return writeTombstone(ctx, RecordTombstone{
+			ID:        objectID,
+			PatientID: deleted.PatientID,
+			DeletedAt: time.Now().UTC(),
+			DeletedBy: currentUser(c),
+		})
+	})
+	if errors.Is(err, ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+		return
+	}
 	if err != nil {
 		logger.WithError(err).Error("Failed to delete medical record")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete record"})
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
-		return
-	}
-
 	logger.WithField("record_id", id).Info("Medical record deleted successfully")
 	c.Status(http.StatusNoContent)
 }
 
+// getPatientSummary aggregates a patient's records. It reads with the
+// analytics read preference, so on secondaries it may briefly lag writes.
+// If the full aggregation exceeds summaryTimeout, a summary of the most
+// recent summaryPartialLimit records is returned with truncated set.
+//
+// @Summary Summarise a patient's records
+// @Tags Patients
+// @Produce json
+// @Param patient_id path string true "Patient ID"
+// @Success 200 {object} PatientSummary
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "The patient has no records"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/patients/{patient_id}/summary [get]
 func getPatientSummary(c *gin.Context) {
-	patientID := c.Param("patient_id")
+	patientID := normalizeID(c.Param("patient_id"))
 	if patientID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Patient ID is required"})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Aggregation pipeline to get patient summary
-	pipeline := []bson.M{
-		{"$match": bson.M{"patient_id": patientID}},
-		{"$group": bson.M{
-			"_id": "$patient_id",
-			"total_records": bson.M{"$sum": 1},
-			"record_types": bson.M{"$addToSet": "$record_type"},
-			"latest_record": bson.M{"$max": "$created_at"},
-			"total_diagnoses": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$diagnosis", []interface{}{}}}}},
-			"total_prescriptions": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$prescriptions", []interface{}{}}}}},
-			"total_lab_results": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$lab_results", []interface{}{}}}}},
-		}},
+	truncated := false
+	summary, err := recordStore.Summary(requestContext(c), patientID, 0, summaryTimeout)
+	if errors.Is(err, ErrQueryTimeout) {
+		logger.WithError(err).Warn("Patient summary timed out, falling back to recent records")
+		truncated = true
+		summary, err = recordStore.Summary(requestContext(c), patientID, summaryPartialLimit, summaryTimeout)
+	}
+	if errors.Is(err, ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No records found for patient"})
+		return
 	}
-
-	cursor, err := db.Collection("medical_records").Aggregate(ctx, pipeline)
 	if err != nil {
 		logger.WithError(err).Error("Failed to aggregate patient summary")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate summary"})
+		respondQueryError(c, err, "Failed to generate summary")
 		return
 	}
-	defer cursor.Close(ctx)
+	summary.Truncated = truncated
 
-	var summaries []bson.M
-	if err := cursor.All(ctx, &summaries); err != nil {
-		logger.WithError(err).Error("Failed to decode patient summary")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode summary"})
-		return
+	// Break lab results down by status so abnormal and critical results stand out
+	if summary.LabResultsByStatus == nil {
+		summary.LabResultsByStatus = map[string]int64{}
 	}
-
-	if len(summaries) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No records found for patient"})
-		return
+	for _, status := range []string{"normal", "abnormal", "critical"} {
+		if _, ok := summary.LabResultsByStatus[status]; !ok {
+			summary.LabResultsByStatus[status] = 0
+		}
 	}
 
-	c.JSON(http.StatusOK, summaries[0])
+	c.JSON(http.StatusOK, summary)
+}
+
+// trustedProxies reads TRUSTED_PROXIES, a comma-separated list of proxy IPs
+// or CIDRs whose X-Forwarded-For headers are believed. It defaults to none,
+// so the client IP is the connection's peer address. Behind a Kubernetes
+// ingress set it to the cluster's pod CIDR, for example "10.244.0.0/16",
+// so the ingress controller's forwarded address is used.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
 }
 
 func setupRouter() *gin.Engine {
@@ -522,31 +2245,102 @@ func setupRouter() *gin.Engine {
 
 	router := gin.New()
 
+	// Only trust X-Forwarded-For from known proxies, so c.ClientIP() cannot
+	// be spoofed by clients
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		logger.WithError(err).Warn("Invalid TRUSTED_PROXIES, trusting no proxies")
+		router.SetTrustedProxies(nil)
+	}
+
 	// Middleware
-	router.Use(gin.Recovery())
+	router.Use(recoveryMiddleware())
 	router.Use(loggingMiddleware())
 	router.Use(prometheusMiddleware())
+	router.Use(inFlightMiddleware())
+	router.Use(corsMiddleware())
+	router.Use(compressionMiddleware())
 
 	// Root endpoint
 	router.GET("/", rootHandler)
 
 	// Health and monitoring endpoints
 	router.GET("/health", healthHandler)
+	router.GET("/version", versionHandler)
 	router.GET("/ready", readinessHandler)
 	router.GET("/metrics", metricsHandler())
 
-	// API routes
-	api := router.Group("/api")
+	// API description and, unless disabled, its interactive docs
+	router.GET("/openapi.json", openAPIHandler())
+	if apiDocsEnabled {
+		router.GET("/docs", docsHandler)
+	}
+
+	// API authentication; health and metrics endpoints are not covered
+	auth := authMiddleware()
+
+	// Every API request acts for one organization and only sees its records
+	org := organizationMiddleware()
+
+	// GraphQL endpoint
+	router.GET("/graphql", auth, org, graphqlHandler)
+	router.POST("/graphql", auth, org, bodyLimitMiddleware(maxBodyBytes), graphqlHandler)
+
+	// Versioned API routes. /api is kept as an alias of v1 so existing
+	// clients keep working while later versions are added alongside.
+	registerV1Routes(router.Group("/api/v1", auth, org))
+	registerV1Routes(router.Group("/api", auth, org))
+
+	return router
+}
+
+// registerV1Routes registers the v1 REST API on group.
+func registerV1Routes(group *gin.RouterGroup) {
+	api := group.Group("", bodyLimitMiddleware(maxBodyBytes))
 	{
 		api.GET("/medical-records", getMedicalRecords)
+		api.GET("/medical-records/count", countMedicalRecords)
+		api.GET("/medical-records/search/prescriptions", searchPrescriptions)
+		api.GET("/medical-records/search/medication", searchPrescriptions)
+		api.GET("/medical-records/ref/:reference", getMedicalRecordByReference)
+		api.GET("/medical-records/changes", getRecordChanges)
 		api.GET("/medical-records/:id", getMedicalRecord)
 		api.POST("/medical-records", createMedicalRecord)
+		api.POST("/medical-records/batch-get", batchGetMedicalRecords)
+		api.POST("/medical-records/:id/clone", cloneMedicalRecord)
 		api.PUT("/medical-records/:id", updateMedicalRecord)
 		api.DELETE("/medical-records/:id", deleteMedicalRecord)
+		api.GET("/medical-records/:id/history", getRecordHistory)
+		api.GET("/medical-records/:id/history/:rev", getRecordRevision)
+		api.POST("/medical-records/:id/diagnoses", addDiagnosis)
+		api.POST("/medical-records/:id/prescriptions", addPrescription)
+		api.PATCH("/medical-records/:id/prescriptions/:index", updatePrescription)
+		api.POST("/medical-records/:id/prescriptions/:index/renew", renewPrescription)
+		api.POST("/medical-records/:id/lab-results", addLabResult)
+		api.PATCH("/medical-records/:id/confidential", requireRoles("doctor", "admin"), setRecordConfidential)
+		api.PUT("/medical-records/:id/consent", setRecordConsent)
+		api.POST("/medical-records/:id/lock", lockRecord)
+		api.DELETE("/medical-records/:id/lock", unlockRecord)
+		api.POST("/medical-records/:id/links", linkRecords)
+		api.DELETE("/medical-records/:id/links/:related_id", unlinkRecords)
+		api.GET("/medical-records/:id/related", getRelatedRecords)
+		api.GET("/medical-records/:id/attachments/:filename", streamingWriteDeadline(), downloadAttachment)
+		api.GET("/medical-records/:id/attachments/:filename/verify", verifyAttachment)
 		api.GET("/patients/:patient_id/summary", getPatientSummary)
+		api.GET("/patients/:patient_id/timeline", getPatientTimeline)
+		api.GET("/patients/:patient_id/alerts", requireRoles("doctor", "nurse"), getPatientAlerts)
+		api.POST("/patients/:patient_id/merge", requireRoles("admin"), mergePatient)
+		api.DELETE("/patients/:patient_id/records", requireRoles("admin"), erasePatientRecords)
+		api.GET("/patients/:patient_id/export.zip", requireRoles("doctor", "admin"), streamingWriteDeadline(), exportPatientData)
+		api.PATCH("/patients/:patient_id/lab-results/status", updateLabResultStatuses)
+		api.GET("/appointments/:appointment_id/records", getAppointmentRecords)
+		api.GET("/stats/records-by-month", getRecordsByMonth)
 	}
 
-	return router
+	// Upload routes get their own, larger body limit
+	uploads := group.Group("", bodyLimitMiddleware(maxUploadBytes))
+	{
+		uploads.POST("/medical-records/:id/attachments", uploadAttachment)
+	}
 }
 
 func main() {
@@ -557,6 +2351,12 @@ func main() {
 		dbName = "medical_records_db"
 	}
 	db = client.Database(dbName)
+	ensureIndexes()
+	backfillOrganization()
+	startRecordMetricsRefresher(getEnvDuration("RECORD_METRICS_INTERVAL", time.Minute))
+	startPrescriptionExpirySweep(getEnvDuration("PRESCRIPTION_EXPIRY_INTERVAL", time.Hour))
+
+	logTimeouts()
 
 	// Setup router
 	router := setupRouter()
@@ -567,12 +2367,7 @@ func main() {
 		port = "3003"
 	}
 
-	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	}
+	server := newServer(":"+port, router)
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -586,19 +2381,10 @@ func main() {
 	}()
 
 	<-quit
-	logger.Info("Shutting down server...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	if err := shutdown(server, client.Disconnect); err != nil {
 		logger.WithError(err).Fatal("Server forced to shutdown")
 	}
 
-	// Close MongoDB connection
-	if err := client.Disconnect(ctx); err != nil {
-		logger.WithError(err).Error("Failed to disconnect from MongoDB")
-	}
-
 	logger.Info("Server exited")
 }
\ No newline at end of file
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memRecordStore is an in-memory RecordStore for handler tests. Records go
+// in and out through BSON, as with MongoDB, so callers never share them
+// and times keep only millisecond precision.
+type memRecordStore struct {
+	mu         sync.Mutex
+	records    map[primitive.ObjectID]MedicalRecord
+	deleted    map[primitive.ObjectID]bool
+	revisions  map[primitive.ObjectID][]RecordRevision
+	tombstones map[primitive.ObjectID]RecordTombstone
+	audit      []AuditEntry
+	sequences  map[int]int64
+	keys       map[string]primitive.ObjectID
+}
+
+func newMemRecordStore() *memRecordStore {
+	return &memRecordStore{
+		records:    map[primitive.ObjectID]MedicalRecord{},
+		deleted:    map[primitive.ObjectID]bool{},
+		revisions:  map[primitive.ObjectID][]RecordRevision{},
+		tombstones: map[primitive.ObjectID]RecordTombstone{},
+		sequences:  map[int]int64{},
+		keys:       map[string]primitive.ObjectID{},
+	}
+}
+
+// roundTrip copies value through BSON.
+func roundTrip[T any](value T) T {
+	return convertBSON[T](value)
+}
+
+// convertBSON decodes the BSON encoding of value as a T.
+func convertBSON[T any](value any) T {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	var converted T
+	if err := bson.Unmarshal(data, &converted); err != nil {
+		panic(err)
+	}
+	return converted
+}
+
+type memTransactionKey struct{}
+
+// Transaction restores the store as it was when fn fails. Nested calls join
+// the outer transaction.
+func (s *memRecordStore) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memTransactionKey{}) != nil {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	records, deleted := maps.Clone(s.records), maps.Clone(s.deleted)
+	revisions, tombstones := maps.Clone(s.revisions), maps.Clone(s.tombstones)
+	audit, sequences := slices.Clone(s.audit), maps.Clone(s.sequences)
+	s.mu.Unlock()
+
+	err := fn(context.WithValue(ctx, memTransactionKey{}, true))
+	if err != nil {
+		s.mu.Lock()
+		s.records, s.deleted = records, deleted
+		s.revisions, s.tombstones = revisions, tombstones
+		s.audit, s.sequences = audit, sequences
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// visible reports whether the organization of ctx sees record.
+func (s *memRecordStore) visible(ctx context.Context, record MedicalRecord, includeDeleted bool) bool {
+	return record.OrganizationID == organizationFrom(ctx) && (includeDeleted || !s.deleted[record.ID])
+}
+
+// duplicate reports whether record would break the one record per type per
+// appointment index, which leaves out erased records.
+func (s *memRecordStore) duplicate(record MedicalRecord) bool {
+	if record.AppointmentID == "" {
+		return false
+	}
+	for _, other := range s.records {
+		if other.ID != record.ID && !s.deleted[other.ID] &&
+			other.OrganizationID == record.OrganizationID && other.PatientID == record.PatientID &&
+			other.AppointmentID == record.AppointmentID && other.RecordType == record.RecordType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memRecordStore) Create(ctx context.Context, record *MedicalRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.OrganizationID = organizationFrom(ctx)
+	if record.ID.IsZero() {
+		record.ID = primitive.NewObjectID()
+	}
+	if _, ok := s.records[record.ID]; ok || s.duplicate(*record) {
+		return ErrDuplicateRecord
+	}
+	s.records[record.ID] = roundTrip(*record)
+	return nil
+}
+
+func (s *memRecordStore) Get(ctx context.Context, id primitive.ObjectID) (MedicalRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok || !s.visible(ctx, record, false) {
+		return MedicalRecord{}, ErrRecordNotFound
+	}
+	return roundTrip(record), nil
+}
+
+func (s *memRecordStore) List(ctx context.Context, query RecordQuery) ([]MedicalRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.query(ctx, query), nil
+}
+
+func (s *memRecordStore) Search(ctx context.Context, query RecordQuery) ([]MedicalRecord, error) {
+	return s.List(ctx, query)
+}
+
+func (s *memRecordStore) Each(ctx context.Context, query RecordQuery, fn func(MedicalRecord) error) error {
+	records, _ := s.List(ctx, query)
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memRecordStore) Count(ctx context.Context, filter RecordFilter) (int64, error) {
+	records, _ := s.List(ctx, RecordQuery{RecordFilter: filter})
+	return int64(len(records)), nil
+}
+
+func (s *memRecordStore) Update(ctx context.Context, id primitive.ObjectID, mutate func(record *MedicalRecord) error) (MedicalRecord, MedicalRecord, error) {
+	for {
+		previous, err := s.Get(ctx, id)
+		if err != nil {
+			return MedicalRecord{}, MedicalRecord{}, err
+		}
+		updated := roundTrip(previous)
+		if err := mutate(&updated); err != nil {
+			return MedicalRecord{}, MedicalRecord{}, err
+		}
+		updated.ID, updated.OrganizationID = previous.ID, previous.OrganizationID
+
+		s.mu.Lock()
+		// Written only while unchanged since the read, as MongoDB does
+		if !s.unchanged(previous) {
+			s.mu.Unlock()
+			continue
+		}
+		if s.duplicate(updated) {
+			s.mu.Unlock()
+			return MedicalRecord{}, MedicalRecord{}, ErrDuplicateRecord
+		}
+		s.records[id] = roundTrip(updated)
+		s.mu.Unlock()
+		return previous, roundTrip(updated), nil
+	}
+}
+
+// unchanged reports whether record is stored exactly as given.
+func (s *memRecordStore) unchanged(record MedicalRecord) bool {
+	stored, ok := s.records[record.ID]
+	if !ok {
+		// Read again to report it missing
+		return false
+	}
+	storedBSON, _ := bson.Marshal(stored)
+	recordBSON, _ := bson.Marshal(record)
+	return bytes.Equal(storedBSON, recordBSON)
+}
+
+func (s *memRecordStore) Delete(ctx context.Context, id primitive.ObjectID) (MedicalRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok || !s.visible(ctx, record, false) {
+		return MedicalRecord{}, ErrRecordNotFound
+	}
+	delete(s.records, id)
+	for otherID, other := range s.records {
+		if s.visible(ctx, other, false) && slices.Contains(other.RelatedRecordIDs, id.Hex()) {
+			other.RelatedRecordIDs = slices.DeleteFunc(slices.Clone(other.RelatedRecordIDs),
+				func(related string) bool { return related == id.Hex() })
+			s.records[otherID] = other
+		}
+	}
+	return roundTrip(record), nil
+}
+
+func (s *memRecordStore) ReassignPatient(ctx context.Context, from, to, user string, at time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var moved int64
+	for id, record := range s.records {
+		if !s.visible(ctx, record, false) || record.PatientID != from {
+			continue
+		}
+		record.PatientID, record.UpdatedAt, record.LastModifiedBy = to, at, user
+		if s.duplicate(record) {
+			return moved, ErrDuplicateRecord
+		}
+		s.records[id] = roundTrip(record)
+		moved++
+	}
+	return moved, nil
+}
+
+func (s *memRecordStore) MarkDeleted(ctx context.Context, ids []primitive.ObjectID, user string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if record, ok := s.records[id]; ok && s.visible(ctx, record, true) {
+			s.deleted[id] = true
+		}
+	}
+	return nil
+}
+
+func (s *memRecordStore) Purge(ctx context.Context, ids []primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if record, ok := s.records[id]; ok && s.visible(ctx, record, true) {
+			delete(s.records, id)
+			delete(s.deleted, id)
+			delete(s.revisions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memRecordStore) SearchPrescriptions(ctx context.Context, query PrescriptionQuery) ([]MedicalRecord, int64, error) {
+	medication := strings.ToLower(query.Medication)
+	matches := func(prescription Prescription) bool {
+		name := strings.ToLower(prescription.MedicationName)
+		if query.Prefix {
+			return strings.HasPrefix(name, medication)
+		}
+		return strings.Contains(name, medication)
+	}
+
+	candidates, _ := s.List(ctx, RecordQuery{
+		RecordFilter: RecordFilter{PatientID: query.PatientID, HasPrescriptions: true},
+		Sort:         []SortField{{Field: "created_at", Descending: true}, {Field: "_id", Descending: true}},
+	})
+	records := []MedicalRecord{}
+	for _, record := range candidates {
+		prescriptions := slices.DeleteFunc(record.Prescriptions, func(p Prescription) bool { return !matches(p) })
+		if len(prescriptions) > 0 {
+			record.Prescriptions = prescriptions
+			records = append(records, record)
+		}
+	}
+	return page(records, query.Skip, query.Limit), int64(len(records)), nil
+}
+
+func (s *memRecordStore) Summary(ctx context.Context, patientID string, limit int, timeout time.Duration) (PatientSummary, error) {
+	records, _ := s.List(ctx, RecordQuery{
+		RecordFilter: RecordFilter{PatientID: patientID},
+		Sort:         []SortField{{Field: "created_at", Descending: true}},
+		Limit:        limit,
+	})
+	if len(records) == 0 {
+		return PatientSummary{}, ErrRecordNotFound
+	}
+
+	summary := PatientSummary{
+		PatientID:          patientID,
+		TotalRecords:       int64(len(records)),
+		RecordsByType:      map[string]int64{},
+		LabResultsByStatus: map[string]int64{},
+		CriticalLabTests:   []string{},
+	}
+	for _, record := range records {
+		if summary.RecordsByType[record.RecordType] == 0 {
+			summary.RecordTypes = append(summary.RecordTypes, record.RecordType)
+		}
+		summary.RecordsByType[record.RecordType]++
+		if record.CreatedAt.After(summary.LatestRecord) {
+			summary.LatestRecord = record.CreatedAt
+		}
+		summary.TotalDiagnoses += int64(len(record.Diagnosis))
+		summary.TotalPrescriptions += int64(len(record.Prescriptions))
+		summary.TotalLabResults += int64(len(record.LabResults))
+		for _, result := range record.LabResults {
+			if result.Status != "" {
+				summary.LabResultsByStatus[result.Status]++
+			}
+			if result.Status == "critical" && !slices.Contains(summary.CriticalLabTests, result.TestName) {
+				summary.CriticalLabTests = append(summary.CriticalLabTests, result.TestName)
+			}
+		}
+	}
+	return summary, nil
+}
+
+func (s *memRecordStore) CountByMonth(ctx context.Context, filter RecordFilter, loc *time.Location) (map[int]int64, error) {
+	records, _ := s.List(ctx, RecordQuery{RecordFilter: filter})
+	months := map[int]int64{}
+	for _, record := range records {
+		months[int(record.CreatedAt.In(loc).Month())]++
+	}
+	return months, nil
+}
+
+func (s *memRecordStore) Timeline(ctx context.Context, patientID string, before time.Time, limit int) ([]TimelineEvent, error) {
+	records, _ := s.List(ctx, RecordQuery{RecordFilter: RecordFilter{PatientID: patientID}})
+
+	events := []TimelineEvent{}
+	add := func(record MedicalRecord, eventType string, date time.Time, summary string) {
+		if date.After(time.Time{}) && (before.IsZero() || date.Before(before)) {
+			events = append(events, TimelineEvent{Type: eventType, Date: date, Summary: summary, RecordID: record.ID})
+		}
+	}
+	for _, record := range records {
+		add(record, "record_created", record.CreatedAt, record.Title)
+		for _, d := range record.Diagnosis {
+			add(record, "diagnosis", d.DateDiagnosed, d.Code+" "+d.Description)
+		}
+		for _, p := range record.Prescriptions {
+			add(record, "prescription", p.PrescribedDate, p.MedicationName+" "+p.Dosage)
+		}
+		for _, l := range record.LabResults {
+			add(record, "lab_result", l.TestDate, l.TestName+": "+l.Result+" "+l.Unit)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Date.Equal(events[j].Date) {
+			return events[i].Date.After(events[j].Date)
+		}
+		return events[i].RecordID.Hex() > events[j].RecordID.Hex()
+	})
+	return page(events, 0, limit), nil
+}
+
+func (s *memRecordStore) Changes(ctx context.Context, after changesCursor, limit int) ([]RecordChange, error) {
+	isAfter := func(at time.Time, id primitive.ObjectID) bool {
+		return at.After(after.ChangedAt) || at.Equal(after.ChangedAt) && id.Hex() > after.ID.Hex()
+	}
+
+	records, _ := s.List(ctx, RecordQuery{})
+	changes := []RecordChange{}
+	for _, record := range records {
+		if isAfter(record.UpdatedAt, record.ID) {
+			record := record
+			changes = append(changes, RecordChange{ID: record.ID, ChangedAt: record.UpdatedAt, Record: &record})
+		}
+	}
+	s.mu.Lock()
+	for _, tombstone := range s.tombstones {
+		if tombstone.OrganizationID == organizationFrom(ctx) && isAfter(tombstone.DeletedAt, tombstone.ID) {
+			changes = append(changes, RecordChange{ID: tombstone.ID, Deleted: true, ChangedAt: tombstone.DeletedAt})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].ChangedAt.Equal(changes[j].ChangedAt) {
+			return changes[i].ChangedAt.Before(changes[j].ChangedAt)
+		}
+		return changes[i].ID.Hex() < changes[j].ID.Hex()
+	})
+	return page(changes, 0, limit), nil
+}
+
+func (s *memRecordStore) NextReferenceNumber(ctx context.Context, now time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	year := now.UTC().Year()
+	s.sequences[year]++
+	return fmt.Sprintf("MR-%d-%06d", year, s.sequences[year]), nil
+}
+
+func (s *memRecordStore) SaveRevision(ctx context.Context, record MedicalRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revisions := slices.Clone(s.revisions[record.ID])
+	s.revisions[record.ID] = append(revisions, roundTrip(RecordRevision{
+		ID:         primitive.NewObjectID(),
+		RecordID:   record.ID,
+		Revision:   len(revisions) + 1,
+		Snapshot:   record,
+		ArchivedAt: time.Now().UTC(),
+	}))
+	return nil
+}
+
+func (s *memRecordStore) Revisions(ctx context.Context, id primitive.ObjectID) ([]RecordRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revisions := []RecordRevision{}
+	for _, revision := range s.revisions[id] {
+		if revision.Snapshot.OrganizationID == organizationFrom(ctx) {
+			revisions = append(revisions, roundTrip(revision))
+		}
+	}
+	return revisions, nil
+}
+
+func (s *memRecordStore) Revision(ctx context.Context, id primitive.ObjectID, rev int) (RecordRevision, error) {
+	revisions, _ := s.Revisions(ctx, id)
+	for _, revision := range revisions {
+		if revision.Revision == rev {
+			return revision, nil
+		}
+	}
+	return RecordRevision{}, ErrRecordNotFound
+}
+
+func (s *memRecordStore) WriteTombstone(ctx context.Context, tombstone RecordTombstone) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tombstones[tombstone.ID] = roundTrip(tombstone)
+	return nil
+}
+
+func (s *memRecordStore) WriteAudit(ctx context.Context, entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func (s *memRecordStore) ClaimIdempotencyKey(ctx context.Context, key string, recordID primitive.ObjectID) (primitive.ObjectID, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.keys[key]; ok {
+		return existing, false, nil
+	}
+	s.keys[key] = recordID
+	return recordID, true, nil
+}
+
+func (s *memRecordStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+// query returns the records of query in order, projected to its fields.
+// The caller holds s.mu.
+func (s *memRecordStore) query(ctx context.Context, query RecordQuery) []MedicalRecord {
+	records := []MedicalRecord{}
+	for _, record := range s.records {
+		if s.visible(ctx, record, query.IncludeDeleted) && matchesRecordFilter(query.RecordFilter, record) {
+			records = append(records, roundTrip(record))
+		}
+	}
+
+	// Map order is random, so _id always settles ties as a stored order would
+	order := append(slices.Clone(query.Sort), SortField{Field: "_id"})
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, field := range order {
+			if c := compareRecordField(records[i], records[j], field.Field); c != 0 {
+				return (c < 0) != field.Descending
+			}
+		}
+		return false
+	})
+
+	records = page(records, query.Skip, query.Limit)
+	if query.Fields != nil {
+		for i := range records {
+			records[i] = projectRecord(records[i], query.Fields)
+		}
+	}
+	return records
+}
+
+// page returns the items left after skipping skip, at most limit of them
+// unless limit is zero.
+func page[T any](items []T, skip, limit int) []T {
+	items = items[min(skip, len(items)):]
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// compareRecordField orders a and b by one of the sortable fields.
+func compareRecordField(a, b MedicalRecord, field string) int {
+	switch field {
+	case "_id":
+		return strings.Compare(a.ID.Hex(), b.ID.Hex())
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "record_type":
+		return strings.Compare(a.RecordType, b.RecordType)
+	}
+	panic("memRecordStore cannot sort by " + field)
+}
+
+// projectRecord keeps only the listed top-level fields of record, and _id.
+func projectRecord(record MedicalRecord, fields []string) MedicalRecord {
+	document := convertBSON[bson.M](record)
+	projected := bson.M{"_id": document["_id"]}
+	for _, field := range fields {
+		if value, ok := document[field]; ok {
+			projected[field] = value
+		}
+	}
+	return convertBSON[MedicalRecord](projected)
+}
+
+// matchesRecordFilter applies f to record as recordFilterDocument's query
+// would.
+func matchesRecordFilter(f RecordFilter, record MedicalRecord) bool {
+	oneOf := func(values []string, value string) bool {
+		return len(values) == 0 || slices.Contains(values, value)
+	}
+	equals := func(want, value string) bool {
+		return want == "" || want == value
+	}
+
+	if f.IDs != nil && !slices.Contains(f.IDs, record.ID) {
+		return false
+	}
+	if !equals(f.PatientID, record.PatientID) || !equals(f.DoctorID, record.DoctorID) ||
+		!equals(f.AppointmentID, record.AppointmentID) || !equals(f.ReferenceNumber, record.ReferenceNumber) ||
+		!oneOf(f.RecordTypes, record.RecordType) {
+		return false
+	}
+
+	if f.DiagnosisCode != "" || len(f.DiagnosisSeverities) > 0 || f.DiagnosisStatus != "" {
+		if !slices.ContainsFunc(record.Diagnosis, func(d Diagnosis) bool {
+			return equals(f.DiagnosisCode, d.Code) && oneOf(f.DiagnosisSeverities, d.Severity) &&
+				equals(f.DiagnosisStatus, d.Status)
+		}) {
+			return false
+		}
+	}
+	if len(f.LabTestCodes) > 0 || f.LabResultStatus != "" {
+		if !slices.ContainsFunc(record.LabResults, func(l LabResult) bool {
+			return oneOf(f.LabTestCodes, l.TestCode) && equals(f.LabResultStatus, l.Status)
+		}) {
+			return false
+		}
+	}
+
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		contains := func(value string) bool { return strings.Contains(strings.ToLower(value), text) }
+		if !contains(record.Title) && !contains(record.Description) &&
+			!slices.ContainsFunc(record.Diagnosis, func(d Diagnosis) bool { return contains(d.Description) }) {
+			return false
+		}
+	}
+
+	if !f.CreatedFrom.IsZero() && record.CreatedAt.Before(f.CreatedFrom) ||
+		!f.CreatedTo.IsZero() && record.CreatedAt.After(f.CreatedTo) {
+		return false
+	}
+	if f.HasPrescriptions && len(record.Prescriptions) == 0 {
+		return false
+	}
+	return !f.AbnormalVitals || len(abnormalVitals(record.ID, record.VitalSigns, record.CreatedAt)) > 0
+}
+
+// interleavedStore runs before ahead of the first transaction, standing in
+// for a request that lands between a handler's early read of a record and
+// the transaction writing it.
+type interleavedStore struct {
+	*memRecordStore
+	pending atomic.Bool
+	before  func()
+}
+
+func newInterleavedStore(store *memRecordStore, before func()) *interleavedStore {
+	s := &interleavedStore{memRecordStore: store, before: before}
+	s.pending.Store(true)
+	return s
+}
+
+func (s *interleavedStore) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.pending.CompareAndSwap(true, false) {
+		s.before()
+	}
+	return s.memRecordStore.Transaction(ctx, fn)
+}
+
+// standaloneStore runs transactions without rolling anything back, as
+// runInTransaction does against a standalone server.
+type standaloneStore struct {
+	*memRecordStore
+}
+
+func (s standaloneStore) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	recordsTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "medical_records_total",
+			Help: "Number of stored medical records by record type",
+		},
+		[]string{"record_type"},
+	)
+
+	mongoOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "mongodb_operation_duration_seconds",
+			Help: "Duration of MongoDB operations issued by the medical records service",
+		},
+		[]string{"operation"},
+	)
+
+	requestsInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "medical_records_requests_in_flight",
+			Help: "Number of requests currently being served",
+		},
+	)
+
+	panicsRecovered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "medical_records_panics_total",
+			Help: "Number of panics recovered while handling requests",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(recordsTotal, mongoOperationDuration, requestsInFlight, panicsRecovered)
+}
+
+// inFlightRequests mirrors the in-flight gauge for logging at shutdown.
+var inFlightRequests atomic.Int64
+
+// inFlightMiddleware tracks the number of concurrent requests.
+func inFlightMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestsInFlight.Inc()
+		inFlightRequests.Add(1)
+		defer func() {
+			requestsInFlight.Dec()
+			inFlightRequests.Add(-1)
+		}()
+		c.Next()
+	}
+}
+
+// recoveryMiddleware recovers from handler panics like gin.Recovery, but
+// also logs them and counts them per endpoint.
+func recoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		endpoint := c.FullPath()
+		panicsRecovered.WithLabelValues(endpoint).Inc()
+		logger.WithFields(logrus.Fields{
+			"endpoint": endpoint,
+			"method":   c.Request.Method,
+			"panic":    fmt.Sprint(recovered),
+		}).Error("Recovered from panic")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	})
+}
+
+// mongoCommandMonitor observes the duration of every MongoDB command in
+// mongoOperationDuration, labelled by command name.
+func mongoCommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			mongoOperationDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			mongoOperationDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// refreshRecordsTotal recomputes the per-type record gauge.
+func refreshRecordsTotal(ctx context.Context) error {
+	pipeline := []bson.M{
+		{"$match": bson.M{"deleted_at": nil}},
+		{"$group": bson.M{"_id": "$record_type", "count": bson.M{"$sum": 1}}},
+	}
+
+	cursor, err := unscopedAnalyticsCollection().Aggregate(ctx, pipeline, aggregateOptions())
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []struct {
+		RecordType string  `bson:"_id"`
+		Count      float64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return err
+	}
+
+	recordsTotal.Reset()
+	for _, count := range counts {
+		recordsTotal.WithLabelValues(count.RecordType).Set(count.Count)
+	}
+	return nil
+}
+
+// startRecordMetricsRefresher refreshes medical_records_total every interval
+// in the background until shutdown. A non-positive interval disables it.
+func startRecordMetricsRefresher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	goBackground(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(backgroundCtx, 30*time.Second)
+			if err := refreshRecordsTotal(ctx); err != nil && backgroundCtx.Err() == nil {
+				logger.WithError(err).Warn("Failed to refresh record metrics")
+			}
+			cancel()
+
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,776 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoRecordStore is the RecordStore backed by MongoDB.
+type mongoRecordStore struct{}
+
+// storeError maps driver errors to the RecordStore errors.
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrRecordNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("%w: %w", ErrDuplicateRecord, err)
+	case mongo.IsTimeout(err):
+		return fmt.Errorf("%w: %w", ErrQueryTimeout, err)
+	}
+	return err
+}
+
+// valueFilter matches a single value directly and several with $in.
+func valueFilter(values []string) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return bson.M{"$in": values}
+}
+
+// elementFilter matches array elements on conditions, with a plain dotted
+// path for a single condition so it can use the multikey index on its
+// field, and $elemMatch for several so they hold for the same element.
+func elementFilter(filter bson.M, array string, conditions bson.M) {
+	switch len(conditions) {
+	case 0:
+	case 1:
+		for field, value := range conditions {
+			filter[array+"."+field] = value
+		}
+	default:
+		filter[array] = bson.M{"$elemMatch": conditions}
+	}
+}
+
+// recordFilterDocument translates f into a query document.
+func recordFilterDocument(f RecordFilter) bson.M {
+	filter := bson.M{}
+	var and bson.A
+
+	if f.IDs != nil {
+		filter["_id"] = bson.M{"$in": f.IDs}
+	}
+	for field, value := range map[string]string{
+		"patient_id":       f.PatientID,
+		"doctor_id":        f.DoctorID,
+		"appointment_id":   f.AppointmentID,
+		"reference_number": f.ReferenceNumber,
+	} {
+		if value != "" {
+			filter[field] = value
+		}
+	}
+	if len(f.RecordTypes) > 0 {
+		filter["record_type"] = valueFilter(f.RecordTypes)
+	}
+
+	diagnosis := bson.M{}
+	if f.DiagnosisCode != "" {
+		diagnosis["code"] = f.DiagnosisCode
+	}
+	if len(f.DiagnosisSeverities) > 0 {
+		diagnosis["severity"] = valueFilter(f.DiagnosisSeverities)
+	}
+	if f.DiagnosisStatus != "" {
+		diagnosis["status"] = f.DiagnosisStatus
+	}
+	elementFilter(filter, "diagnosis", diagnosis)
+
+	labResult := bson.M{}
+	if len(f.LabTestCodes) > 0 {
+		labResult["test_code"] = valueFilter(f.LabTestCodes)
+	}
+	if f.LabResultStatus != "" {
+		labResult["status"] = f.LabResultStatus
+	}
+	elementFilter(filter, "lab_results", labResult)
+
+	if f.Text != "" {
+		// Matched literally so user input cannot form an expensive pattern
+		regex := primitive.Regex{Pattern: regexp.QuoteMeta(f.Text), Options: "i"}
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"title": regex},
+			bson.M{"description": regex},
+			bson.M{"diagnosis.description": regex},
+		}})
+	}
+
+	createdAt := bson.M{}
+	if !f.CreatedFrom.IsZero() {
+		createdAt["$gte"] = f.CreatedFrom
+	}
+	if !f.CreatedTo.IsZero() {
+		createdAt["$lte"] = f.CreatedTo
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	if f.HasPrescriptions {
+		filter["prescriptions.medication_name"] = bson.M{"$exists": true}
+	}
+	if f.AbnormalVitals {
+		and = append(and, bson.M{"$or": abnormalVitalsFilter()})
+	}
+
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+	return filter
+}
+
+// abnormalVitalsFilter lists conditions matching a measured vital sign
+// outside normalVitalRanges, as abnormalVitals finds them. Temperatures
+// above fahrenheitThreshold are compared against the range in Fahrenheit.
+func abnormalVitalsFilter() bson.A {
+	var conditions bson.A
+	for _, vital := range vitalValues(&VitalSigns{}) {
+		field := "vital_signs." + vital.field
+		r := normalVitalRanges[vital.field]
+		conditions = append(conditions, bson.M{field: bson.M{"$ne": 0, "$lt": r.Min}})
+		if vital.field != "temperature" {
+			conditions = append(conditions, bson.M{field: bson.M{"$gt": r.Max}})
+			continue
+		}
+		fahrenheit := func(celsius float64) float64 { return celsius*9/5 + 32 }
+		conditions = append(conditions,
+			bson.M{field: bson.M{"$gt": r.Max, "$lte": fahrenheitThreshold}},
+			bson.M{field: bson.M{"$gt": fahrenheitThreshold, "$lt": fahrenheit(r.Min)}},
+			bson.M{field: bson.M{"$gt": fahrenheit(r.Max)}},
+		)
+	}
+	return conditions
+}
+
+// sortDocument translates sort into a sort document.
+func sortDocument(sort []SortField) bson.D {
+	document := make(bson.D, 0, len(sort))
+	for _, field := range sort {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+		document = append(document, bson.E{Key: field.Field, Value: direction})
+	}
+	return document
+}
+
+// queryFindOptions returns the find options of query, carrying
+// queryMaxTime.
+func queryFindOptions(query RecordQuery) *options.FindOptions {
+	opts := findOptions()
+	if len(query.Sort) > 0 {
+		opts.SetSort(sortDocument(query.Sort))
+	}
+	if query.Fields != nil {
+		projection := bson.M{}
+		for _, field := range query.Fields {
+			projection[field] = 1
+		}
+		opts.SetProjection(projection)
+	}
+	if query.Skip > 0 {
+		opts.SetSkip(int64(query.Skip))
+	}
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+	return opts
+}
+
+// filtered returns collection restricted to live records unless filter
+// includes soft-deleted ones.
+func filtered(collection scopedCollection, filter RecordFilter) scopedCollection {
+	collection.live = !filter.IncludeDeleted
+	return collection
+}
+
+// Transaction runs fn in a transaction, or joins the one ctx is already
+// in. On a standalone server fn runs without a transaction.
+func (mongoRecordStore) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !transactionsSupported || mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func (mongoRecordStore) Create(ctx context.Context, record *MedicalRecord) error {
+	return storeError(insertRecord(ctx, record))
+}
+
+func (mongoRecordStore) Get(ctx context.Context, id primitive.ObjectID) (MedicalRecord, error) {
+	var record MedicalRecord
+	err := recordsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	return record, storeError(err)
+}
+
+func (mongoRecordStore) List(ctx context.Context, query RecordQuery) ([]MedicalRecord, error) {
+	return findRecordsIn(ctx, filtered(recordsCollection(), query.RecordFilter), query)
+}
+
+func (mongoRecordStore) Search(ctx context.Context, query RecordQuery) ([]MedicalRecord, error) {
+	return findRecordsIn(ctx, filtered(analyticsCollection(), query.RecordFilter), query)
+}
+
+func (mongoRecordStore) Each(ctx context.Context, query RecordQuery, fn func(MedicalRecord) error) error {
+	opts := queryFindOptions(query)
+	// Iteration runs at the caller's pace, which the server limit would
+	// count against it
+	opts.MaxTime = nil
+	collection := filtered(recordsCollection(), query.RecordFilter)
+	cursor, err := collection.Find(ctx, recordFilterDocument(query.RecordFilter), opts)
+	if err != nil {
+		return storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var record MedicalRecord
+		if err := cursor.Decode(&record); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return storeError(cursor.Err())
+}
+
+func (mongoRecordStore) Count(ctx context.Context, filter RecordFilter) (int64, error) {
+	collection := filtered(analyticsCollection(), filter)
+	count, err := collection.CountDocuments(ctx, recordFilterDocument(filter), countOptions())
+	return count, storeError(err)
+}
+
+// Update replaces the record with its mutated copy. The write only matches
+// while the record is as it was read; when another write got there first
+// the record is read and mutated again, so concurrent writes all land as
+// they would updating the record in place.
+func (s mongoRecordStore) Update(ctx context.Context, id primitive.ObjectID, mutate func(record *MedicalRecord) error) (MedicalRecord, MedicalRecord, error) {
+	var previous, updated MedicalRecord
+	err := s.Transaction(ctx, func(ctx context.Context) error {
+		for {
+			raw, err := recordsCollection().FindOne(ctx, bson.M{"_id": id}).DecodeBytes()
+			if err != nil {
+				return storeError(err)
+			}
+			// Decoded twice so mutate cannot change previous through a
+			// shared array
+			previous, updated = MedicalRecord{}, MedicalRecord{}
+			if err := bson.Unmarshal(raw, &previous); err != nil {
+				return err
+			}
+			if err := bson.Unmarshal(raw, &updated); err != nil {
+				return err
+			}
+			if err := mutate(&updated); err != nil {
+				return err
+			}
+			updated.ID, updated.OrganizationID = previous.ID, previous.OrganizationID
+
+			result, err := recordsCollection().ReplaceOne(ctx, unchangedRecordFilter(id, raw), updated)
+			if err != nil {
+				return storeError(err)
+			}
+			if result.MatchedCount > 0 {
+				return nil
+			}
+		}
+	})
+	return previous, updated, err
+}
+
+// unchangedRecordFilter matches the record with id only while it is
+// stored exactly as raw, which it was read as.
+func unchangedRecordFilter(id primitive.ObjectID, raw bson.Raw) bson.M {
+	return bson.M{
+		"_id":   id,
+		"$expr": bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": raw}}},
+	}
+}
+
+func (mongoRecordStore) Delete(ctx context.Context, id primitive.ObjectID) (MedicalRecord, error) {
+	var deleted MedicalRecord
+	if err := recordsCollection().FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&deleted); err != nil {
+		return deleted, storeError(err)
+	}
+	_, err := recordsCollection().UpdateMany(ctx,
+		bson.M{"related_record_ids": id.Hex()},
+		bson.M{"$pull": bson.M{"related_record_ids": id.Hex()}},
+	)
+	return deleted, storeError(err)
+}
+
+func (mongoRecordStore) ReassignPatient(ctx context.Context, from, to, user string, at time.Time) (int64, error) {
+	result, err := recordsCollection().UpdateMany(ctx,
+		bson.M{"patient_id": from},
+		bson.M{"$set": bson.M{
+			"patient_id":       to,
+			"updated_at":       at,
+			"last_modified_by": user,
+		}},
+	)
+	if err != nil {
+		return 0, storeError(err)
+	}
+	return result.ModifiedCount, nil
+}
+
+func (mongoRecordStore) MarkDeleted(ctx context.Context, ids []primitive.ObjectID, user string, at time.Time) error {
+	_, err := recordsCollection().UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"deleted_at": at, "deleted_by": user}},
+	)
+	return storeError(err)
+}
+
+func (mongoRecordStore) Purge(ctx context.Context, ids []primitive.ObjectID) error {
+	collection := filtered(recordsCollection(), RecordFilter{IncludeDeleted: true})
+	if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return storeError(err)
+	}
+	_, err := historyRecords().DeleteMany(ctx, bson.M{"record_id": bson.M{"$in": ids}})
+	return storeError(err)
+}
+
+// SearchPrescriptions unwinds the prescriptions of matching records so
+// each can be regrouped with only its matching entries.
+func (mongoRecordStore) SearchPrescriptions(ctx context.Context, query PrescriptionQuery) ([]MedicalRecord, int64, error) {
+	pattern := regexp.QuoteMeta(query.Medication)
+	if query.Prefix {
+		pattern = "^" + pattern
+	}
+	medicationMatch := bson.M{"prescriptions.medication_name": primitive.Regex{Pattern: pattern, Options: "i"}}
+
+	recordMatch := bson.M{"prescriptions.medication_name": medicationMatch["prescriptions.medication_name"]}
+	if query.PatientID != "" {
+		recordMatch["patient_id"] = query.PatientID
+	}
+
+	pipeline := []bson.M{
+		{"$match": recordMatch},
+		{"$unwind": "$prescriptions"},
+		{"$match": medicationMatch},
+		{"$group": bson.M{
+			"_id":           "$_id",
+			"record":        bson.M{"$first": "$$ROOT"},
+			"prescriptions": bson.M{"$push": "$prescriptions"},
+		}},
+		{"$replaceRoot": bson.M{"newRoot": bson.M{"$mergeObjects": []interface{}{"$record", bson.M{"prescriptions": "$prescriptions"}}}}},
+		{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{"$facet": bson.M{
+			"total":   []bson.M{{"$count": "count"}},
+			"records": []bson.M{{"$skip": query.Skip}, {"$limit": query.Limit}},
+		}},
+	}
+
+	cursor, err := analyticsCollection().Aggregate(ctx, pipeline, aggregateOptions())
+	if err != nil {
+		return nil, 0, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Total   []struct{ Count int64 } `bson:"total"`
+		Records []MedicalRecord         `bson:"records"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, 0, storeError(err)
+	}
+
+	var total int64
+	records := []MedicalRecord{}
+	if len(results) > 0 {
+		if len(results[0].Total) > 0 {
+			total = results[0].Total[0].Count
+		}
+		if results[0].Records != nil {
+			records = results[0].Records
+		}
+	}
+	return records, total, nil
+}
+
+// patientSummaryResult is one $facet document from summaryPipeline.
+type patientSummaryResult struct {
+	Summary []struct {
+		PatientID          string    `bson:"_id"`
+		TotalRecords       int64     `bson:"total_records"`
+		RecordTypes        []string  `bson:"record_types"`
+		LatestRecord       time.Time `bson:"latest_record"`
+		TotalDiagnoses     int64     `bson:"total_diagnoses"`
+		TotalPrescriptions int64     `bson:"total_prescriptions"`
+		TotalLabResults    int64     `bson:"total_lab_results"`
+	} `bson:"summary"`
+	RecordTypes []struct {
+		Type  string `bson:"_id"`
+		Count int64  `bson:"count"`
+	} `bson:"record_types"`
+	LabStatuses []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	} `bson:"lab_statuses"`
+	CriticalTests []struct {
+		Names []string `bson:"names"`
+	} `bson:"critical_tests"`
+}
+
+// summaryPipeline builds the patient summary aggregation. Every breakdown
+// is a $facet branch so the records are read in a single pass. A positive
+// limit restricts it to the patient's most recent records.
+func summaryPipeline(patientID string, limit int) []bson.M {
+	pipeline := []bson.M{{"$match": bson.M{"patient_id": patientID}}}
+	if limit > 0 {
+		pipeline = append(pipeline,
+			bson.M{"$sort": bson.M{"created_at": -1}},
+			bson.M{"$limit": limit},
+		)
+	}
+
+	arraySize := func(field string) bson.M {
+		return bson.M{"$size": bson.M{"$ifNull": []interface{}{field, []interface{}{}}}}
+	}
+	return append(pipeline, bson.M{"$facet": bson.M{
+		"summary": []bson.M{
+			{"$group": bson.M{
+				"_id":                 "$patient_id",
+				"total_records":       bson.M{"$sum": 1},
+				"record_types":        bson.M{"$addToSet": "$record_type"},
+				"latest_record":       bson.M{"$max": "$created_at"},
+				"total_diagnoses":     bson.M{"$sum": arraySize("$diagnosis")},
+				"total_prescriptions": bson.M{"$sum": arraySize("$prescriptions")},
+				"total_lab_results":   bson.M{"$sum": arraySize("$lab_results")},
+			}},
+		},
+		"record_types": []bson.M{
+			{"$group": bson.M{"_id": "$record_type", "count": bson.M{"$sum": 1}}},
+		},
+		"lab_statuses": []bson.M{
+			{"$unwind": "$lab_results"},
+			{"$group": bson.M{"_id": "$lab_results.status", "count": bson.M{"$sum": 1}}},
+		},
+		"critical_tests": []bson.M{
+			{"$unwind": "$lab_results"},
+			{"$match": bson.M{"lab_results.status": "critical"}},
+			{"$group": bson.M{"_id": nil, "names": bson.M{"$addToSet": "$lab_results.test_name"}}},
+		},
+	}})
+}
+
+func (mongoRecordStore) Summary(ctx context.Context, patientID string, limit int, timeout time.Duration) (PatientSummary, error) {
+	// Leave the server time to report the limit before the client gives up
+	ctx, cancel := context.WithTimeout(ctx, timeout+2*time.Second)
+	defer cancel()
+
+	opts := options.Aggregate().SetMaxTime(timeout).SetHint(patientIndexHint)
+	cursor, err := analyticsCollection().Aggregate(ctx, summaryPipeline(patientID, limit), opts)
+	if err != nil {
+		return PatientSummary{}, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []patientSummaryResult
+	if err := cursor.All(ctx, &results); err != nil {
+		return PatientSummary{}, storeError(err)
+	}
+	if len(results) == 0 || len(results[0].Summary) == 0 {
+		return PatientSummary{}, ErrRecordNotFound
+	}
+
+	result := results[0]
+	totals := result.Summary[0]
+	summary := PatientSummary{
+		PatientID:          totals.PatientID,
+		TotalRecords:       totals.TotalRecords,
+		RecordTypes:        totals.RecordTypes,
+		LatestRecord:       totals.LatestRecord,
+		TotalDiagnoses:     totals.TotalDiagnoses,
+		TotalPrescriptions: totals.TotalPrescriptions,
+		TotalLabResults:    totals.TotalLabResults,
+		RecordsByType:      map[string]int64{},
+		LabResultsByStatus: map[string]int64{},
+		CriticalLabTests:   []string{},
+	}
+	for _, recordType := range result.RecordTypes {
+		summary.RecordsByType[recordType.Type] = recordType.Count
+	}
+	for _, status := range result.LabStatuses {
+		if status.Status != "" {
+			summary.LabResultsByStatus[status.Status] = status.Count
+		}
+	}
+	if len(result.CriticalTests) > 0 {
+		summary.CriticalLabTests = result.CriticalTests[0].Names
+	}
+	return summary, nil
+}
+
+func (mongoRecordStore) CountByMonth(ctx context.Context, filter RecordFilter, loc *time.Location) (map[int]int64, error) {
+	pipeline := []bson.M{
+		{"$match": recordFilterDocument(filter)},
+		{"$group": bson.M{
+			"_id":   bson.M{"$month": bson.M{"date": "$created_at", "timezone": loc.String()}},
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+
+	cursor, err := filtered(analyticsCollection(), filter).Aggregate(ctx, pipeline, aggregateOptions())
+	if err != nil {
+		return nil, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	var counts []struct {
+		Month int   `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, storeError(err)
+	}
+
+	months := make(map[int]int64, len(counts))
+	for _, count := range counts {
+		months[count.Month] = count.Count
+	}
+	return months, nil
+}
+
+// stringOrEmpty is an aggregation expression for field that yields "" when
+// it is missing, since $concat returns null if any part is null.
+func stringOrEmpty(field string) bson.M {
+	return bson.M{"$ifNull": bson.A{field, ""}}
+}
+
+// timelineEvents is an aggregation expression building the events of one
+// record: its creation plus each diagnosis, prescription and lab result.
+func timelineEvents() bson.M {
+	eventsFrom := func(field, variable, eventType, date string, summary bson.A) bson.M {
+		return bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$" + field, bson.A{}}},
+			"as":    variable,
+			"in": bson.M{
+				"type":      eventType,
+				"date":      "$$" + variable + "." + date,
+				"summary":   bson.M{"$concat": summary},
+				"record_id": "$_id",
+			},
+		}}
+	}
+
+	return bson.M{"$concatArrays": bson.A{
+		bson.A{bson.M{
+			"type":      "record_created",
+			"date":      "$created_at",
+			"summary":   stringOrEmpty("$title"),
+			"record_id": "$_id",
+		}},
+		eventsFrom("diagnosis", "d", "diagnosis", "date_diagnosed",
+			bson.A{stringOrEmpty("$$d.code"), " ", stringOrEmpty("$$d.description")}),
+		eventsFrom("prescriptions", "p", "prescription", "prescribed_date",
+			bson.A{stringOrEmpty("$$p.medication_name"), " ", stringOrEmpty("$$p.dosage")}),
+		eventsFrom("lab_results", "l", "lab_result", "test_date",
+			bson.A{stringOrEmpty("$$l.test_name"), ": ", stringOrEmpty("$$l.result"), " ", stringOrEmpty("$$l.unit")}),
+	}}
+}
+
+func (mongoRecordStore) Timeline(ctx context.Context, patientID string, before time.Time, limit int) ([]TimelineEvent, error) {
+	// Undated entries have no place on the timeline
+	dateMatch := bson.M{"$gt": time.Time{}}
+	if !before.IsZero() {
+		dateMatch["$lt"] = before
+	}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"patient_id": patientID}},
+		{"$project": bson.M{"events": timelineEvents()}},
+		{"$unwind": "$events"},
+		{"$replaceRoot": bson.M{"newRoot": "$events"}},
+		{"$match": bson.M{"date": dateMatch}},
+		{"$sort": bson.D{{Key: "date", Value: -1}, {Key: "record_id", Value: -1}}},
+		{"$limit": limit},
+	}
+
+	cursor, err := analyticsCollection().Aggregate(ctx, pipeline, aggregateOptions().SetHint(patientIndexHint))
+	if err != nil {
+		return nil, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	events := []TimelineEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, storeError(err)
+	}
+	return events, nil
+}
+
+func (mongoRecordStore) Changes(ctx context.Context, after changesCursor, limit int) ([]RecordChange, error) {
+	// position matches changes after the cursor against the timestamp
+	// field of either collection
+	position := func(field string) bson.M {
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$gt": after.ChangedAt}},
+			bson.M{field: after.ChangedAt, "_id": bson.M{"$gt": after.ID}},
+		}}
+	}
+
+	// The position is matched on each side of the union before projecting
+	// so both can use their updated_at and deleted_at indexes
+	pipeline := []bson.M{
+		{"$match": position("updated_at")},
+		{"$project": bson.M{"changed_at": "$updated_at", "deleted": bson.M{"$literal": false}, "record": "$$ROOT"}},
+		{"$unionWith": bson.M{
+			"coll": tombstoneCollection,
+			"pipeline": []bson.M{
+				{"$match": bson.M{"organization_id": organizationFrom(ctx)}},
+				{"$match": position("deleted_at")},
+				{"$project": bson.M{"changed_at": "$deleted_at", "deleted": bson.M{"$literal": true}}},
+			},
+		}},
+		{"$sort": bson.D{{Key: "changed_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{"$limit": limit},
+	}
+
+	cursor, err := recordsCollection().Aggregate(ctx, pipeline, aggregateOptions())
+	if err != nil {
+		return nil, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	changes := []RecordChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, storeError(err)
+	}
+	return changes, nil
+}
+
+// NextReferenceNumber advances the year's sequence with a single atomic
+// upserting findAndModify, so concurrent creates never share a number.
+func (mongoRecordStore) NextReferenceNumber(ctx context.Context, now time.Time) (string, error) {
+	year := now.UTC().Year()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := db.Collection(countersCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": fmt.Sprintf("medical_record_ref_%d", year)},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		opts,
+	).Decode(&counter)
+	if err != nil {
+		return "", storeError(err)
+	}
+	return fmt.Sprintf("MR-%d-%06d", year, counter.Seq), nil
+}
+
+func (mongoRecordStore) SaveRevision(ctx context.Context, record MedicalRecord) error {
+	var latest RecordRevision
+	opts := options.FindOne().SetSort(bson.D{{Key: "revision", Value: -1}})
+	err := historyRecords().FindOne(ctx, bson.M{"record_id": record.ID}, opts).Decode(&latest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return storeError(err)
+	}
+
+	revision := RecordRevision{
+		RecordID:   record.ID,
+		Revision:   latest.Revision + 1,
+		Snapshot:   record,
+		ArchivedAt: time.Now().UTC(),
+	}
+	_, err = db.Collection(historyCollection).InsertOne(ctx, revision)
+	return storeError(err)
+}
+
+func (mongoRecordStore) Revisions(ctx context.Context, id primitive.ObjectID) ([]RecordRevision, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: 1}})
+	cursor, err := historyRecords().Find(ctx, bson.M{"record_id": id}, opts)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []RecordRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, storeError(err)
+	}
+	return revisions, nil
+}
+
+func (mongoRecordStore) Revision(ctx context.Context, id primitive.ObjectID, rev int) (RecordRevision, error) {
+	var revision RecordRevision
+	err := historyRecords().FindOne(ctx, bson.M{"record_id": id, "revision": rev}).Decode(&revision)
+	return revision, storeError(err)
+}
+
+func (mongoRecordStore) WriteTombstone(ctx context.Context, tombstone RecordTombstone) error {
+	_, err := db.Collection(tombstoneCollection).ReplaceOne(ctx,
+		bson.M{"_id": tombstone.ID}, tombstone, options.Replace().SetUpsert(true))
+	return storeError(err)
+}
+
+func (mongoRecordStore) WriteAudit(ctx context.Context, entry AuditEntry) error {
+	_, err := db.Collection(auditCollection).InsertOne(ctx, entry)
+	return storeError(err)
+}
+
+// ClaimIdempotencyKey relies on the key being the document _id, so only
+// one insert of it can succeed.
+func (mongoRecordStore) ClaimIdempotencyKey(ctx context.Context, key string, recordID primitive.ObjectID) (primitive.ObjectID, bool, error) {
+	entry := idempotencyKey{Key: key, RecordID: recordID, CreatedAt: time.Now().UTC()}
+
+	_, err := db.Collection(idempotencyKeysCollection).InsertOne(ctx, entry)
+	if err == nil {
+		return recordID, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, false, storeError(err)
+	}
+
+	var existing idempotencyKey
+	if err := db.Collection(idempotencyKeysCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&existing); err != nil {
+		return primitive.NilObjectID, false, storeError(err)
+	}
+	return existing.RecordID, false, nil
+}
+
+func (mongoRecordStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := db.Collection(idempotencyKeysCollection).DeleteOne(ctx, bson.M{"_id": key})
+	return storeError(err)
+}
+
+// findRecordsIn loads the records matching query from collection.
+func findRecordsIn(ctx context.Context, collection scopedCollection, query RecordQuery) ([]MedicalRecord, error) {
+	cursor, err := collection.Find(ctx, recordFilterDocument(query.RecordFilter), queryFindOptions(query))
+	if err != nil {
+		return nil, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	records := []MedicalRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, storeError(err)
+	}
+	return records, nil
+}
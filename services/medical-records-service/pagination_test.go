@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetMedicalRecordsValidatesPagination(t *testing.T) {
+	api := newTestAPI(t)
+	api.seed(testDoctor, MedicalRecord{})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantError string
+	}{
+		{name: "defaults", query: ""},
+		{name: "page zero", query: "?page=0", wantError: "page must be a positive integer"},
+		{name: "page not a number", query: "?page=abc", wantError: "page must be a positive integer"},
+		{name: "limit zero", query: "?limit=0", wantError: "limit must be a positive integer"},
+		{name: "limit not a number", query: "?limit=abc", wantError: "limit must be a positive integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(testDoctor, http.MethodGet, "/api/v1/medical-records"+tt.query, nil)
+			if tt.wantError == "" {
+				expectStatus(t, w, http.StatusOK)
+				return
+			}
+			expectStatus(t, w, http.StatusBadRequest)
+			if got := decodeBody[map[string]interface{}](t, w)["error"]; got != tt.wantError {
+				t.Errorf("error = %v, want %q", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestParsePaginationBounds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := maxPageLimit
+	maxPageLimit = 100
+	t.Cleanup(func() { maxPageLimit = previous })
+
+	tests := []struct {
+		name        string
+		query       string
+		wantPage    int
+		wantLimit   int
+		wantClamped bool
+		wantErr     bool
+	}{
+		{name: "smallest", query: "?page=1&limit=1", wantPage: 1, wantLimit: 1},
+		{name: "limit at max", query: "?limit=100", wantPage: 1, wantLimit: 100},
+		{name: "limit over max", query: "?limit=101", wantPage: 1, wantLimit: 100, wantClamped: true},
+		{name: "negative limit", query: "?limit=-5", wantErr: true},
+		{name: "negative page", query: "?page=-1", wantErr: true},
+		{name: "fractional limit", query: "?limit=2.5", wantErr: true},
+		{name: "empty page", query: "?page=", wantErr: true},
+		{name: "page overflowing the skip", query: "?page=2147483647&limit=100", wantErr: true},
+		{name: "page past int", query: "?page=99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/medical-records"+tt.query, nil)
+
+			page, limit, clamped, err := parsePagination(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsePagination() = %d, %d, want error", page, limit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePagination() error = %v", err)
+			}
+			if page != tt.wantPage || limit != tt.wantLimit || clamped != tt.wantClamped {
+				t.Errorf("parsePagination() = %d, %d, %v, want %d, %d, %v",
+					page, limit, clamped, tt.wantPage, tt.wantLimit, tt.wantClamped)
+			}
+		})
+	}
+}
+
+// pageLimitVariables are every variable loadPageLimits reads.
+var pageLimitVariables = []string{"MAX_PAGE_LIMIT", "MAX_PAGE_SIZE", "MAX_LIMIT", "DEFAULT_PAGE_LIMIT", "DEFAULT_PAGE_SIZE"}
+
+// setPageLimits loads the page limits from env, leaving the other page limit
+// variables unset, and restores the previous limits after the test.
+func setPageLimits(t *testing.T, env map[string]string) {
+	t.Helper()
+	previousDefault, previousMax := defaultPageLimit, maxPageLimit
+	t.Cleanup(func() { defaultPageLimit, maxPageLimit = previousDefault, previousMax })
+	for _, key := range pageLimitVariables {
+		t.Setenv(key, env[key])
+	}
+	loadPageLimits()
+}
+
+func TestLoadPageLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantDefault int
+		wantMax     int
+	}{
+		{name: "unset", wantDefault: 10, wantMax: 100},
+		{name: "configured", env: map[string]string{"DEFAULT_PAGE_LIMIT": "25", "MAX_PAGE_LIMIT": "50"}, wantDefault: 25, wantMax: 50},
+		{name: "default above max", env: map[string]string{"DEFAULT_PAGE_LIMIT": "80", "MAX_PAGE_LIMIT": "50"}, wantDefault: 10, wantMax: 50},
+		{name: "max below the built-in default", env: map[string]string{"MAX_PAGE_LIMIT": "5"}, wantDefault: 5, wantMax: 5},
+		{name: "invalid max", env: map[string]string{"MAX_PAGE_LIMIT": "0"}, wantDefault: 10, wantMax: 100},
+		{name: "page size aliases", env: map[string]string{"DEFAULT_PAGE_SIZE": "20", "MAX_PAGE_SIZE": "40"}, wantDefault: 20, wantMax: 40},
+		{name: "max limit alias", env: map[string]string{"MAX_LIMIT": "30"}, wantDefault: 10, wantMax: 30},
+		{
+			name:        "canonical names win over aliases",
+			env:         map[string]string{"DEFAULT_PAGE_LIMIT": "15", "DEFAULT_PAGE_SIZE": "20", "MAX_PAGE_LIMIT": "60", "MAX_PAGE_SIZE": "40"},
+			wantDefault: 15,
+			wantMax:     60,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPageLimits(t, tt.env)
+			if defaultPageLimit != tt.wantDefault || maxPageLimit != tt.wantMax {
+				t.Errorf("limits = default %d, max %d; want %d, %d", defaultPageLimit, maxPageLimit, tt.wantDefault, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestGetMedicalRecordsAppliesPageLimits(t *testing.T) {
+	api := newTestAPI(t)
+	for i := 0; i < 6; i++ {
+		api.seed(testDoctor, MedicalRecord{})
+	}
+	setPageLimits(t, map[string]string{"DEFAULT_PAGE_SIZE": "2", "MAX_PAGE_SIZE": "4"})
+
+	tests := []struct {
+		name        string
+		query       string
+		wantLimit   int
+		wantClamped bool
+	}{
+		{name: "defaulted", query: "", wantLimit: 2},
+		{name: "within max", query: "?limit=3", wantLimit: 3},
+		{name: "at max", query: "?limit=4", wantLimit: 4},
+		{name: "clamped", query: "?limit=1000000", wantLimit: 4, wantClamped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(testDoctor, http.MethodGet, "/api/v1/medical-records"+tt.query, nil)
+			expectStatus(t, w, http.StatusOK)
+			got := decodeBody[struct {
+				Records      []MedicalRecord `json:"records"`
+				Limit        int             `json:"limit"`
+				LimitClamped bool            `json:"limit_clamped"`
+			}](t, w)
+			if got.Limit != tt.wantLimit || len(got.Records) != tt.wantLimit || got.LimitClamped != tt.wantClamped {
+				t.Errorf("limit = %d with %d records, clamped %v; want %d, clamped %v",
+					got.Limit, len(got.Records), got.LimitClamped, tt.wantLimit, tt.wantClamped)
+			}
+		})
+	}
+}
+
+func TestGetMedicalRecordsPagesThroughEqualTimestamps(t *testing.T) {
+	api := newTestAPI(t)
+	imported := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 7; i++ {
+		ids = append(ids, api.seed(testDoctor, MedicalRecord{CreatedAt: imported}).ID.Hex())
+	}
+	slices.Sort(ids)
+
+	tests := []struct {
+		name       string
+		sort       string
+		descending bool
+	}{
+		{name: "default order", sort: "", descending: true},
+		{name: "ascending", sort: "created_at"},
+		{name: "descending", sort: "-created_at", descending: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for page := 1; page <= 3; page++ {
+				w := api.do(testDoctor, http.MethodGet, fmt.Sprintf("/api/v1/medical-records?limit=3&page=%d&sort=%s", page, tt.sort), nil)
+				expectStatus(t, w, http.StatusOK)
+				for _, record := range decodeBody[struct {
+					Records []MedicalRecord `json:"records"`
+				}](t, w).Records {
+					got = append(got, record.ID.Hex())
+				}
+			}
+
+			// _id settles the ties, in the direction of the sort
+			want := slices.Clone(ids)
+			if tt.descending {
+				slices.Reverse(want)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("paged IDs = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseSortBreaksTiesByID(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []SortField
+	}{
+		{value: "", want: []SortField{{Field: "created_at", Descending: true}, {Field: "_id", Descending: true}}},
+		{value: "title", want: []SortField{{Field: "title"}, {Field: "_id"}}},
+		{value: "-updated_at", want: []SortField{{Field: "updated_at", Descending: true}, {Field: "_id", Descending: true}}},
+	}
+	for _, tt := range tests {
+		got, err := parseSort(tt.value)
+		if err != nil {
+			t.Fatalf("parseSort(%q) error = %v", tt.value, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseSort(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxPatientAlerts caps how many records are read for each category of a
+// patient's alerts and how many items each alert list holds
+// (MAX_PATIENT_ALERTS).
+var maxPatientAlerts = 100
+
+// alertDiagnosisSeverities are the diagnosis severities reported as alerts
+// while the diagnosis is active.
+var alertDiagnosisSeverities = []string{"severe", "critical"}
+
+// labResultAlert is a critical lab result and the record holding it.
+type labResultAlert struct {
+	RecordID primitive.ObjectID `json:"record_id"`
+	LabResult
+}
+
+// diagnosisAlert is a severe or critical active diagnosis and the record
+// holding it.
+type diagnosisAlert struct {
+	RecordID primitive.ObjectID `json:"record_id"`
+	Diagnosis
+}
+
+// vitalAlert is a vital sign reading outside its normal range.
+type vitalAlert struct {
+	RecordID   primitive.ObjectID `json:"record_id"`
+	Field      string             `json:"field"`
+	Value      float64            `json:"value"`
+	NormalMin  float64            `json:"normal_min"`
+	NormalMax  float64            `json:"normal_max"`
+	MeasuredAt time.Time          `json:"measured_at"`
+}
+
+// abnormalVitals returns the readings of v outside normalVitalRanges.
+// Fahrenheit temperatures are compared in Celsius but reported as sent.
+func abnormalVitals(recordID primitive.ObjectID, v *VitalSigns, measuredAt time.Time) []vitalAlert {
+	if v == nil {
+		return nil
+	}
+	if !v.MeasuredAt.IsZero() {
+		measuredAt = v.MeasuredAt
+	}
+
+	var alerts []vitalAlert
+	for _, vital := range vitalValues(v) {
+		if vital.value == 0 {
+			continue
+		}
+		value := vital.value
+		if vital.field == "temperature" && value > fahrenheitThreshold {
+			value = (value - 32) * 5 / 9
+		}
+		r := normalVitalRanges[vital.field]
+		if value >= r.Min && value <= r.Max {
+			continue
+		}
+		alerts = append(alerts, vitalAlert{
+			RecordID:   recordID,
+			Field:      vital.field,
+			Value:      vital.value,
+			NormalMin:  r.Min,
+			NormalMax:  r.Max,
+			MeasuredAt: measuredAt,
+		})
+	}
+	return alerts
+}
+
+// appendAlerts appends found to alerts up to maxPatientAlerts and reports
+// whether any were left out.
+func appendAlerts[T any](alerts []T, found ...T) ([]T, bool) {
+	if room := maxPatientAlerts - len(alerts); len(found) > room {
+		return append(alerts, found[:room]...), true
+	}
+	return append(alerts, found...), false
+}
+
+// patientAlertRecords returns the patient's most recent maxPatientAlerts
+// records matching filter, newest first, with only field loaded, and
+// whether more matched.
+func patientAlertRecords(ctx context.Context, patientID string, filter RecordFilter, field string) ([]MedicalRecord, bool, error) {
+	filter.PatientID = patientID
+	records, err := recordStore.Search(ctx, RecordQuery{
+		RecordFilter: filter,
+		Fields:       []string{"created_at", field},
+		Sort:         []SortField{{Field: "created_at", Descending: true}, {Field: "_id", Descending: true}},
+		Limit:        maxPatientAlerts + 1,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(records) > maxPatientAlerts {
+		return records[:maxPatientAlerts], true, nil
+	}
+	return records, false, nil
+}
+
+// getPatientAlerts returns a patient's critical lab results, severe or
+// critical active diagnoses and abnormal vital signs in one payload, for a
+// quick view in emergencies. Each category is queried on its own, from the
+// patient's most recent maxPatientAlerts records holding any of it, so a
+// busy category cannot crowd out the others. Items are newest first and
+// each list is capped at maxPatientAlerts; truncated reports when any cut
+// applied.
+//
+// @Summary A patient's critical lab results, severe active diagnoses and abnormal vitals
+// @Description Requires the doctor or nurse role.
+// @Tags Patients
+// @Produce json
+// @Param patient_id path string true "Patient ID"
+// @Success 200 {object} object{patient_id=string,critical_lab_results=[]labResultAlert,critical_diagnoses=[]diagnosisAlert,abnormal_vitals=[]vitalAlert,truncated=bool}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 403 {object} apiError "The caller's role is not allowed"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/patients/{patient_id}/alerts [get]
+func getPatientAlerts(c *gin.Context) {
+	patientID := normalizeID(c.Param("patient_id"))
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	labResults := []labResultAlert{}
+	diagnoses := []diagnosisAlert{}
+	vitals := []vitalAlert{}
+	truncated := false
+
+	categories := []struct {
+		filter RecordFilter
+		field  string
+		// add adds the record's alerts and reports whether any were cut
+		add func(record MedicalRecord) bool
+	}{
+		{
+			filter: RecordFilter{LabResultStatus: "critical"},
+			field:  "lab_results",
+			add: func(record MedicalRecord) bool {
+				var found []labResultAlert
+				for _, result := range detectCriticalResults(record) {
+					found = append(found, labResultAlert{RecordID: record.ID, LabResult: result})
+				}
+				var cut bool
+				labResults, cut = appendAlerts(labResults, found...)
+				return cut
+			},
+		},
+		{
+			filter: RecordFilter{DiagnosisSeverities: alertDiagnosisSeverities, DiagnosisStatus: "active"},
+			field:  "diagnosis",
+			add: func(record MedicalRecord) bool {
+				var found []diagnosisAlert
+				for _, diagnosis := range record.Diagnosis {
+					if diagnosis.Status == "active" && slices.Contains(alertDiagnosisSeverities, diagnosis.Severity) {
+						found = append(found, diagnosisAlert{RecordID: record.ID, Diagnosis: diagnosis})
+					}
+				}
+				var cut bool
+				diagnoses, cut = appendAlerts(diagnoses, found...)
+				return cut
+			},
+		},
+		{
+			filter: RecordFilter{AbnormalVitals: true},
+			field:  "vital_signs",
+			add: func(record MedicalRecord) bool {
+				var cut bool
+				vitals, cut = appendAlerts(vitals, abnormalVitals(record.ID, record.VitalSigns, record.CreatedAt)...)
+				return cut
+			},
+		},
+	}
+
+	for _, category := range categories {
+		records, more, err := patientAlertRecords(ctx, patientID, category.filter, category.field)
+		if err != nil {
+			logger.WithError(err).Error("Failed to fetch patient alerts")
+			respondQueryError(c, err, "Failed to fetch alerts")
+			return
+		}
+		truncated = truncated || more
+		for _, record := range records {
+			if category.add(record) {
+				truncated = true
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"patient_id":           patientID,
+		"critical_lab_results": labResults,
+		"critical_diagnoses":   diagnoses,
+		"abnormal_vitals":      vitals,
+		"truncated":            truncated,
+	})
+}
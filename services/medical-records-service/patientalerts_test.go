@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAbnormalVitals(t *testing.T) {
+	tests := []struct {
+		name   string
+		vitals *VitalSigns
+		want   []string
+	}{
+		{name: "not measured", vitals: nil},
+		{name: "normal", vitals: &VitalSigns{HeartRate: 72, Temperature: 36.8, OxygenSaturation: 98}},
+		{name: "zero is unmeasured", vitals: &VitalSigns{HeartRate: 0, RespiratoryRate: 16}},
+		{name: "tachycardia", vitals: &VitalSigns{HeartRate: 130}, want: []string{"heart_rate"}},
+		{name: "fever in celsius", vitals: &VitalSigns{Temperature: 39.2}, want: []string{"temperature"}},
+		{name: "normal in fahrenheit", vitals: &VitalSigns{Temperature: 98.6}},
+		{name: "fever in fahrenheit", vitals: &VitalSigns{Temperature: 102.5}, want: []string{"temperature"}},
+		{name: "hypothermia in fahrenheit", vitals: &VitalSigns{Temperature: 94}, want: []string{"temperature"}},
+		{
+			name:   "several",
+			vitals: &VitalSigns{BloodPressureSystolic: 180, BloodPressureDiastolic: 110, OxygenSaturation: 88},
+			want:   []string{"blood_pressure_systolic", "blood_pressure_diastolic", "oxygen_saturation"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := abnormalVitals(primitive.NilObjectID, tt.vitals, time.Time{})
+			if len(alerts) != len(tt.want) {
+				t.Fatalf("abnormalVitals() = %+v, want fields %v", alerts, tt.want)
+			}
+			for i, alert := range alerts {
+				if alert.Field != tt.want[i] {
+					t.Errorf("alert %d field = %s, want %s", i, alert.Field, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetPatientAlerts(t *testing.T) {
+	previous := maxPatientAlerts
+	maxPatientAlerts = 2
+	t.Cleanup(func() { maxPatientAlerts = previous })
+
+	now := time.Now().UTC()
+	criticalLab := MedicalRecord{
+		CreatedAt:  now.Add(-72 * time.Hour),
+		LabResults: []LabResult{{TestName: "Potassium", Result: "6.9", Status: "critical"}, {TestName: "Sodium", Result: "140", Status: "normal"}},
+	}
+	severeDiagnosis := MedicalRecord{
+		CreatedAt: now.Add(-48 * time.Hour),
+		Diagnosis: []Diagnosis{
+			{Code: "I21", Description: "Myocardial infarction", Severity: "critical", Status: "active"},
+			{Code: "J45", Description: "Asthma", Severity: "severe", Status: "resolved"},
+		},
+	}
+	normalVitals := func(hoursAgo int) MedicalRecord {
+		return MedicalRecord{CreatedAt: now.Add(-time.Duration(hoursAgo) * time.Hour), VitalSigns: &VitalSigns{HeartRate: 70}}
+	}
+	fever := func(hoursAgo int) MedicalRecord {
+		return MedicalRecord{CreatedAt: now.Add(-time.Duration(hoursAgo) * time.Hour), VitalSigns: &VitalSigns{Temperature: 39.5}}
+	}
+
+	tests := []struct {
+		name          string
+		caller        testCaller
+		records       []MedicalRecord
+		wantStatus    int
+		wantLabs      int
+		wantDiagnoses int
+		wantVitals    int
+		wantTruncated bool
+	}{
+		{name: "no records", caller: testNurse, wantStatus: http.StatusOK},
+		{name: "clinical roles only", caller: testAdmin, wantStatus: http.StatusForbidden},
+		{
+			// Recent routine vitals must not push older alerts out of reach
+			name:          "each category found past newer records",
+			caller:        testDoctor,
+			records:       []MedicalRecord{criticalLab, severeDiagnosis, normalVitals(3), normalVitals(2), normalVitals(1)},
+			wantStatus:    http.StatusOK,
+			wantLabs:      1,
+			wantDiagnoses: 1,
+		},
+		{
+			name:          "category capped",
+			caller:        testDoctor,
+			records:       []MedicalRecord{criticalLab, fever(3), fever(2), fever(1)},
+			wantStatus:    http.StatusOK,
+			wantLabs:      1,
+			wantVitals:    2,
+			wantTruncated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			for _, record := range tt.records {
+				api.seed(testDoctor, record)
+			}
+			api.seed(testDoctor, MedicalRecord{PatientID: "PAT-2", LabResults: criticalLab.LabResults})
+
+			w := api.do(tt.caller, http.MethodGet, "/api/v1/patients/PAT-1/alerts", nil)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			got := decodeBody[struct {
+				LabResults []labResultAlert `json:"critical_lab_results"`
+				Diagnoses  []diagnosisAlert `json:"critical_diagnoses"`
+				Vitals     []vitalAlert     `json:"abnormal_vitals"`
+				Truncated  bool             `json:"truncated"`
+			}](t, w)
+			if len(got.LabResults) != tt.wantLabs || len(got.Diagnoses) != tt.wantDiagnoses ||
+				len(got.Vitals) != tt.wantVitals || got.Truncated != tt.wantTruncated {
+				t.Errorf("alerts = %d labs, %d diagnoses, %d vitals, truncated %v; want %d, %d, %d, %v",
+					len(got.LabResults), len(got.Diagnoses), len(got.Vitals), got.Truncated,
+					tt.wantLabs, tt.wantDiagnoses, tt.wantVitals, tt.wantTruncated)
+			}
+		})
+	}
+}
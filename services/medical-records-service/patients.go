@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type mergePatientRequest struct {
+	TargetPatientID string `json:"target_patient_id" binding:"required"`
+}
+
+// mergePatient reassigns every record of a duplicate patient ID to the
+// target patient in a single transaction with an audit entry. The source
+// ID is used exactly as given, so records stored under IDs from before
+// normalization can be merged into their normalized form.
+//
+// @Summary Merge a duplicate patient into another
+// @Description Requires the admin role.
+// @Tags Patients
+// @Accept json
+// @Produce json
+// @Param patient_id path string true "Patient ID"
+// @Param request body mergePatientRequest true "The patient to merge into"
+// @Success 200 {object} object{source_patient_id=string,target_patient_id=string,records_moved=int}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 403 {object} apiError "The caller's role is not allowed"
+// @Failure 409 {object} apiError "Both patients have a record of the same type for the same appointment"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/patients/{patient_id}/merge [post]
+func mergePatient(c *gin.Context) {
+	patientID := c.Param("patient_id")
+
+	var req mergePatientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	req.TargetPatientID = normalizeID(req.TargetPatientID)
+	if !patientIDPattern.MatchString(req.TargetPatientID) {
+		respondIDFormatError(c, &IDFormatError{Field: "target_patient_id", Value: req.TargetPatientID})
+		return
+	}
+	if req.TargetPatientID == patientID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot merge a patient into itself"})
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	var moved int64
+	err := runInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		moved, err = recordStore.ReassignPatient(ctx, patientID, req.TargetPatientID, currentUser(c), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		entry := newAuditEntry(c, "patient_merge")
+		entry.PatientID = patientID
+		entry.Details = map[string]interface{}{
+			"target_patient_id": req.TargetPatientID,
+			"records_moved":     moved,
+		}
+		return writeAudit(ctx, entry)
+	})
+	if err != nil {
+		if errors.Is(err, ErrDuplicateRecord) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Both patients have a record of the same type for the same appointment"})
+			return
+		}
+		logger.WithError(err).Error("Failed to merge patient records")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge patient"})
+		return
+	}
+
+	logger.WithField("records_moved", moved).Info("Patient records merged successfully")
+	c.JSON(http.StatusOK, gin.H{
+		"source_patient_id": patientID,
+		"target_patient_id": req.TargetPatientID,
+		"records_moved":     moved,
+	})
+}
+
+// erasureConfirmationHeader must carry the patient ID being erased, so a
+// stray request cannot wipe a patient's records by accident.
+const erasureConfirmationHeader = "X-Confirm-Erasure"
+
+// erasePatientRecords erases all of a patient's records for
+// right-to-erasure requests. By default the erasure is soft: records are
+// flagged with deleted_at and deleted_by and drop out of every read, but
+// stay stored with their history. With hard=true records, history and
+// attachment files are removed, including records an earlier soft erasure
+// kept. Records are erased maxBatchSize at a time, each batch in its own
+// transaction, so a large patient does not need one unbounded transaction.
+// Either way tombstones are written for sync clients and one audit entry
+// covers the request, recording how far a failed erasure got.
+//
+// @Summary Erase all of a patient's records
+// @Description Requires the admin role.
+// @Tags Patients
+// @Produce json
+// @Param patient_id path string true "Patient ID"
+// @Param hard query bool false "Delete records, history and attachment files instead of flagging records as deleted" default(false)
+// @Param X-Confirm-Erasure header string true "Must repeat the patient ID"
+// @Success 200 {object} object{patient_id=string,records_erased=int,hard=bool}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 403 {object} apiError "The caller's role is not allowed"
+// @Failure 428 {object} apiError "Confirmation header missing or wrong"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/patients/{patient_id}/records [delete]
+func erasePatientRecords(c *gin.Context) {
+	patientID := normalizeID(c.Param("patient_id"))
+	if normalizeID(c.GetHeader(erasureConfirmationHeader)) != patientID {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error": "Erasure must be confirmed by sending the patient ID in the " + erasureConfirmationHeader + " header",
+		})
+		return
+	}
+	hard, err := strconv.ParseBool(c.DefaultQuery("hard", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hard must be true or false"})
+		return
+	}
+
+	erased := 0
+	for {
+		var batch []primitive.ObjectID
+		batch, err = eraseRecordBatch(c, patientID, hard)
+		if err != nil || len(batch) == 0 {
+			break
+		}
+		erased += len(batch)
+
+		// Files are outside the transaction, so they go only once it committed
+		if hard {
+			for _, id := range batch {
+				if err := os.RemoveAll(filepath.Join(attachmentDir, id.Hex())); err != nil {
+					logger.WithError(err).WithField("record_id", id.Hex()).Warn("Failed to remove erased record attachments")
+				}
+			}
+		}
+		if len(batch) < maxBatchSize {
+			break
+		}
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	entry := newAuditEntry(c, "patient_erasure")
+	entry.PatientID = patientID
+	entry.Details = map[string]interface{}{
+		"hard":           hard,
+		"records_erased": erased,
+		"complete":       err == nil,
+	}
+	if auditErr := writeAudit(ctx, entry); err == nil {
+		err = auditErr
+	}
+	if err != nil {
+		logger.WithError(err).WithField("records_erased", erased).Error("Failed to erase patient records")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":          "Failed to erase patient records",
+			"records_erased": erased,
+		})
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"records_erased": erased,
+		"hard":           hard,
+	}).Info("Patient records erased")
+	c.JSON(http.StatusOK, gin.H{
+		"patient_id":     patientID,
+		"records_erased": erased,
+		"hard":           hard,
+	})
+}
+
+// eraseRecordBatch erases up to maxBatchSize of the patient's records in
+// one transaction and returns their IDs. Hard erasure also takes records
+// soft-deleted before. It returns none once the patient has no records
+// left to erase.
+func eraseRecordBatch(c *gin.Context, patientID string, hard bool) ([]primitive.ObjectID, error) {
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	var erased []primitive.ObjectID
+	err := runInTransaction(ctx, func(ctx context.Context) error {
+		erased = nil
+		records, err := findRecords(ctx, RecordQuery{
+			RecordFilter: RecordFilter{PatientID: patientID, IncludeDeleted: hard},
+			Fields:       []string{"_id"},
+			Limit:        maxBatchSize,
+		})
+		if err != nil || len(records) == 0 {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, record := range records {
+			tombstone := RecordTombstone{ID: record.ID, PatientID: patientID, DeletedAt: now, DeletedBy: currentUser(c)}
+			if err := writeTombstone(ctx, tombstone); err != nil {
+				return err
+			}
+			erased = append(erased, record.ID)
+		}
+
+		if !hard {
+			return recordStore.MarkDeleted(ctx, erased, currentUser(c), now)
+		}
+		return recordStore.Purge(ctx, erased)
+	})
+	return erased, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// queryMaxTime is the server-side time limit (maxTimeMS) on list, search
+// and aggregation queries (QUERY_MAX_TIME), so a pathological query is
+// stopped by MongoDB instead of running on after the client gives up.
+// Zero or less sets no limit.
+var queryMaxTime = 20 * time.Second
+
+// patientIndexHint names the patient_id/created_at index created by
+// ensureIndexes, for aggregations scoped to one patient.
+var patientIndexHint = bson.D{{Key: "patient_id", Value: 1}, {Key: "created_at", Value: -1}}
+
+// aggregateOptions returns aggregate options carrying queryMaxTime.
+func aggregateOptions() *options.AggregateOptions {
+	opts := options.Aggregate()
+	if queryMaxTime > 0 {
+		opts.SetMaxTime(queryMaxTime)
+	}
+	return opts
+}
+
+// findOptions returns find options carrying queryMaxTime.
+func findOptions() *options.FindOptions {
+	opts := options.Find()
+	if queryMaxTime > 0 {
+		opts.SetMaxTime(queryMaxTime)
+	}
+	return opts
+}
+
+// countOptions returns count options carrying queryMaxTime.
+func countOptions() *options.CountOptions {
+	opts := options.Count()
+	if queryMaxTime > 0 {
+		opts.SetMaxTime(queryMaxTime)
+	}
+	return opts
+}
+
+// respondQueryError writes the response for a failed query: 503 when it
+// was aborted by a time limit, so clients know to narrow it or retry, and
+// a 500 with message otherwise.
+func respondQueryError(c *gin.Context, err error, message string) {
+	if errors.Is(err, ErrQueryTimeout) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Query exceeded the time limit; narrow the filters or retry later"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordUpdate is a PUT body for the records seeded by testAPI.seed with a
+// new title.
+func recordUpdate(title string) map[string]interface{} {
+	return map[string]interface{}{
+		"patient_id":  "PAT-1",
+		"doctor_id":   testDoctor.user,
+		"record_type": "consultation",
+		"title":       title,
+	}
+}
+
+func TestCreateAndGetMedicalRecord(t *testing.T) {
+	api := newTestAPI(t)
+
+	w := api.do(testDoctor, http.MethodPost, "/api/v1/medical-records", map[string]interface{}{
+		"patient_id":  "pat-1",
+		"doctor_id":   "doc-1",
+		"record_type": "consultation",
+		"title":       "Annual checkup",
+	})
+	expectStatus(t, w, http.StatusCreated)
+	created := decodeBody[MedicalRecord](t, w)
+	if created.PatientID != "PAT-1" || created.ReferenceNumber == "" {
+		t.Fatalf("created = %+v, want normalized patient ID and a reference number", created)
+	}
+
+	tests := []struct {
+		name   string
+		caller testCaller
+		want   int
+	}{
+		{name: "same organization", caller: testOtherDoctor, want: http.StatusOK},
+		{name: "other organization", caller: testOtherClinic, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(tt.caller, http.MethodGet, recordURL(created.ID), nil)
+			expectStatus(t, w, tt.want)
+		})
+	}
+
+	w = api.do(testDoctor, http.MethodGet, "/api/v1/medical-records/ref/"+created.ReferenceNumber, nil)
+	expectStatus(t, w, http.StatusOK)
+	if got := decodeBody[MedicalRecord](t, w); got.ID != created.ID {
+		t.Errorf("record by reference = %s, want %s", got.ID.Hex(), created.ID.Hex())
+	}
+	w = api.do(testDoctor, http.MethodGet, "/api/v1/medical-records/ref/MR-1999-000001", nil)
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestCreateMedicalRecordRejectsDuplicateAppointmentRecord(t *testing.T) {
+	api := newTestAPI(t)
+	api.seed(testDoctor, MedicalRecord{AppointmentID: "APT-1"})
+
+	w := api.do(testDoctor, http.MethodPost, "/api/v1/medical-records", map[string]interface{}{
+		"patient_id":     "PAT-1",
+		"doctor_id":      "DOC-1",
+		"appointment_id": "APT-1",
+		"record_type":    "consultation",
+		"title":          "Retried checkup",
+	})
+	expectStatus(t, w, http.StatusConflict)
+}
+
+func TestAppointmentRecordUniquenessSkipsOtherClinicsAndErasedRecords(t *testing.T) {
+	api := newTestAPI(t)
+	api.seed(testOtherClinic, MedicalRecord{AppointmentID: "APT-1"})
+	body := recordUpdate("Checkup")
+	body["appointment_id"] = "APT-1"
+
+	// Another clinic's record with the same IDs neither blocks nor shows
+	expectStatus(t, api.do(testDoctor, http.MethodPost, "/api/v1/medical-records", body), http.StatusCreated)
+
+	w := api.doWithHeaders(testAdmin, http.MethodDelete, "/api/v1/patients/PAT-1/records", nil,
+		map[string]string{"X-Confirm-Erasure": "PAT-1"})
+	expectStatus(t, w, http.StatusOK)
+	expectStatus(t, api.do(testDoctor, http.MethodPost, "/api/v1/medical-records", body), http.StatusCreated)
+}
+
+func TestCreateMedicalRecordValidatesEachElement(t *testing.T) {
+	tests := []struct {
+		name      string
+		field     string
+		elements  []map[string]interface{}
+		wantField string
+	}{
+		{
+			name:  "diagnosis",
+			field: "diagnosis",
+			elements: []map[string]interface{}{
+				{"code": "J45", "description": "Asthma", "severity": "mild", "status": "active"},
+				{"code": "I10", "description": "Hypertension", "severity": "extreme", "status": "active"},
+			},
+			wantField: "diagnosis[1].severity",
+		},
+		{
+			name:  "prescriptions",
+			field: "prescriptions",
+			elements: []map[string]interface{}{
+				{"medication_name": "Salbutamol", "dosage": "100mcg", "frequency": "as needed"},
+				{"medication_name": "Lisinopril", "frequency": "daily"},
+			},
+			wantField: "prescriptions[1].dosage",
+		},
+		{
+			name:  "lab results",
+			field: "lab_results",
+			elements: []map[string]interface{}{
+				{"test_name": "Sodium", "result": "140", "status": "normal"},
+				{"test_name": "Potassium", "result": "6.9", "status": "urgent"},
+			},
+			wantField: "lab_results[1].status",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			body := recordUpdate("Checkup")
+			body[tt.field] = tt.elements
+
+			w := api.do(testDoctor, http.MethodPost, "/api/v1/medical-records", body)
+			expectStatus(t, w, http.StatusUnprocessableEntity)
+			fields := decodeBody[struct {
+				Fields map[string]string `json:"fields"`
+			}](t, w).Fields
+			if _, ok := fields[tt.wantField]; !ok || len(fields) != 1 {
+				t.Errorf("fields = %v, want only %s", fields, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestCountMedicalRecords(t *testing.T) {
+	api := newTestAPI(t)
+	march := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	api.seed(testDoctor, MedicalRecord{CreatedAt: march})
+	api.seed(testDoctor, MedicalRecord{CreatedAt: march.AddDate(0, 1, 0), RecordType: "lab_result"})
+	api.seed(testDoctor, MedicalRecord{CreatedAt: march, PatientID: "PAT-2"})
+	erased := api.seed(testDoctor, MedicalRecord{CreatedAt: march, PatientID: "PAT-3"})
+	api.seed(testOtherClinic, MedicalRecord{CreatedAt: march})
+	if err := api.store.MarkDeleted(testDoctor.context(), []primitive.ObjectID{erased.ID}, testAdmin.user, march); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       int64
+	}{
+		{name: "unfiltered", query: "", wantStatus: http.StatusOK, want: 3},
+		{name: "patient", query: "?patient_id=pat-1", wantStatus: http.StatusOK, want: 2},
+		{name: "record type", query: "?record_type=lab_result", wantStatus: http.StatusOK, want: 1},
+		{name: "date range", query: "?date_from=2024-03-01&date_to=2024-03-31", wantStatus: http.StatusOK, want: 2},
+		{name: "erased patient", query: "?patient_id=PAT-3", wantStatus: http.StatusOK, want: 0},
+		{name: "invalid record type", query: "?record_type=x-ray", wantStatus: http.StatusBadRequest},
+		{name: "invalid date", query: "?date_from=March", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(testDoctor, http.MethodGet, "/api/v1/medical-records/count"+tt.query, nil)
+			expectStatus(t, w, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := decodeBody[struct {
+				Count int64 `json:"count"`
+			}](t, w).Count; got != tt.want {
+				t.Errorf("count = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateMedicalRecordSavesRevision(t *testing.T) {
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{
+		Diagnosis: []Diagnosis{{Code: "J06", Description: "Cold", Severity: "mild", Status: "active"}},
+	})
+
+	w := api.do(testDoctor, http.MethodPut, recordURL(record.ID), recordUpdate("Follow-up"))
+	expectStatus(t, w, http.StatusOK)
+
+	stored, err := api.stored(testDoctor, record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Follow-up" || len(stored.Diagnosis) != 1 {
+		t.Errorf("stored = %q with %d diagnoses, want the new title and the diagnosis kept", stored.Title, len(stored.Diagnosis))
+	}
+
+	w = api.do(testDoctor, http.MethodGet, recordURL(record.ID)+"/history/1", nil)
+	expectStatus(t, w, http.StatusOK)
+	if revision := decodeBody[RecordRevision](t, w); revision.Snapshot.Title != "Checkup" {
+		t.Errorf("revision 1 title = %q, want %q", revision.Snapshot.Title, "Checkup")
+	}
+
+	w = api.do(testDoctor, http.MethodPut, recordURL(primitive.NewObjectID()), recordUpdate("Missing"))
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestRefusedUpdateSavesNoRevision(t *testing.T) {
+	api := newTestAPI(t)
+	recordStore = standaloneStore{api.store}
+	api.seed(testDoctor, MedicalRecord{AppointmentID: "APT-1"})
+	record := api.seed(testDoctor, MedicalRecord{AppointmentID: "APT-1", RecordType: "diagnosis"})
+	body := recordUpdate("Checkup")
+	body["appointment_id"] = "APT-1"
+
+	w := api.do(testDoctor, http.MethodPut, recordURL(record.ID), body)
+	expectStatus(t, w, http.StatusConflict)
+
+	w = api.do(testDoctor, http.MethodGet, recordURL(record.ID)+"/history", nil)
+	expectStatus(t, w, http.StatusOK)
+	if total := decodeBody[struct {
+		Total int `json:"total"`
+	}](t, w).Total; total != 0 {
+		t.Errorf("history has %d revisions, want none for a refused update", total)
+	}
+}
+
+func TestUpdateMedicalRecordValidatesBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    map[string]interface{}
+		wantField string
+	}{
+		{name: "valid", change: map[string]interface{}{}},
+		{name: "record type", change: map[string]interface{}{"record_type": "x-ray"}, wantField: "record_type"},
+		{name: "missing title", change: map[string]interface{}{"title": ""}, wantField: "title"},
+		{
+			name: "sent diagnosis",
+			change: map[string]interface{}{"diagnosis": []map[string]interface{}{
+				{"code": "J45", "severity": "mild", "status": "active"},
+			}},
+			wantField: "diagnosis[0].description",
+		},
+		{
+			name: "sent prescription",
+			change: map[string]interface{}{"prescriptions": []map[string]interface{}{
+				{"medication_name": "Lisinopril", "frequency": "daily"},
+			}},
+			wantField: "prescriptions[0].dosage",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			// Stored nested fields the body leaves out are not checked
+			record := api.seed(testDoctor, MedicalRecord{LabResults: []LabResult{{TestName: "Sodium"}}})
+			body := recordUpdate("Follow-up")
+			for field, value := range tt.change {
+				body[field] = value
+			}
+
+			w := api.do(testDoctor, http.MethodPut, recordURL(record.ID), body)
+			if tt.wantField == "" {
+				expectStatus(t, w, http.StatusOK)
+				return
+			}
+			expectStatus(t, w, http.StatusUnprocessableEntity)
+			fields := decodeBody[struct {
+				Fields map[string]string `json:"fields"`
+			}](t, w).Fields
+			if _, ok := fields[tt.wantField]; !ok || len(fields) != 1 {
+				t.Errorf("fields = %v, want only %s", fields, tt.wantField)
+			}
+			if stored, _ := api.stored(testDoctor, record.ID); stored.Title != "Checkup" {
+				t.Errorf("stored title = %q, want the record unchanged", stored.Title)
+			}
+		})
+	}
+}
+
+func TestConcurrentAppendsAllLand(t *testing.T) {
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{})
+
+	const appends = 10
+	codes := make([]int, appends)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = api.do(testDoctor, http.MethodPost, recordURL(record.ID)+"/prescriptions", map[string]interface{}{
+				"medication_name": fmt.Sprintf("Medication %d", i),
+				"dosage":          "10mg",
+				"frequency":       "daily",
+			}).Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("append %d status = %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	stored, err := api.stored(testDoctor, record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Prescriptions) != appends {
+		t.Errorf("stored prescriptions = %d, want %d", len(stored.Prescriptions), appends)
+	}
+}
+
+func TestUpsertMedicalRecordCreatesMissingRecord(t *testing.T) {
+	api := newTestAPI(t)
+	id := primitive.NewObjectID()
+
+	w := api.do(testDoctor, http.MethodPut, recordURL(id)+"?upsert=true", recordUpdate("Synced offline"))
+	expectStatus(t, w, http.StatusCreated)
+
+	stored, err := api.stored(testDoctor, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Synced offline" || stored.ReferenceNumber == "" || stored.CreatedBy != testDoctor.user {
+		t.Errorf("stored = %+v, want the upserted record with a reference and creator", stored)
+	}
+}
+
+func TestRecordLocksBlockOtherEditors(t *testing.T) {
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{})
+
+	expectStatus(t, api.do(testDoctor, http.MethodPost, recordURL(record.ID)+"/lock", nil), http.StatusOK)
+	expectStatus(t, api.do(testOtherDoctor, http.MethodPost, recordURL(record.ID)+"/lock", nil), http.StatusLocked)
+
+	writes := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{name: "update", method: http.MethodPut, path: "", body: recordUpdate("Changed")},
+		{name: "add diagnosis", method: http.MethodPost, path: "/diagnoses", body: map[string]interface{}{
+			"code": "J06", "description": "Cold", "severity": "mild", "status": "active",
+		}},
+		{name: "link", method: http.MethodPost, path: "/links", body: map[string]interface{}{
+			"related_id": api.seed(testDoctor, MedicalRecord{}).ID.Hex(),
+		}},
+		{name: "confidential", method: http.MethodPatch, path: "/confidential", body: map[string]interface{}{"is_confidential": false}},
+	}
+	for _, tt := range writes {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(testOtherDoctor, tt.method, recordURL(record.ID)+tt.path, tt.body)
+			expectStatus(t, w, http.StatusLocked)
+		})
+	}
+
+	stored, err := api.stored(testDoctor, record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Checkup" || len(stored.Diagnosis) != 0 || len(stored.RelatedRecordIDs) != 0 {
+		t.Errorf("locked record changed: %+v", stored)
+	}
+
+	// Only the holder can release the lock
+	expectStatus(t, api.do(testOtherDoctor, http.MethodDelete, recordURL(record.ID)+"/lock", nil), http.StatusLocked)
+	expectStatus(t, api.do(testDoctor, http.MethodDelete, recordURL(record.ID)+"/lock", nil), http.StatusNoContent)
+	expectStatus(t, api.do(testOtherDoctor, http.MethodPut, recordURL(record.ID), recordUpdate("Changed")), http.StatusOK)
+}
+
+func TestRecordLinks(t *testing.T) {
+	api := newTestAPI(t)
+	consultation := api.seed(testDoctor, MedicalRecord{})
+	labResult := api.seed(testDoctor, MedicalRecord{RecordType: "lab_result", Title: "Blood panel"})
+	otherPatient := api.seed(testDoctor, MedicalRecord{PatientID: "PAT-2"})
+
+	w := api.do(testDoctor, http.MethodPost, recordURL(consultation.ID)+"/links", map[string]interface{}{
+		"related_id": otherPatient.ID.Hex(),
+	})
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = api.do(testDoctor, http.MethodPost, recordURL(consultation.ID)+"/links", map[string]interface{}{
+		"related_id": labResult.ID.Hex(),
+	})
+	expectStatus(t, w, http.StatusOK)
+
+	for _, tt := range []struct {
+		from, to MedicalRecord
+	}{
+		{from: consultation, to: labResult},
+		{from: labResult, to: consultation},
+	} {
+		w := api.do(testDoctor, http.MethodGet, recordURL(tt.from.ID)+"/related", nil)
+		expectStatus(t, w, http.StatusOK)
+		related := decodeBody[struct {
+			Related []MedicalRecord `json:"related"`
+		}](t, w).Related
+		if len(related) != 1 || related[0].ID != tt.to.ID {
+			t.Errorf("related to %s = %+v, want only %s", tt.from.Title, related, tt.to.ID.Hex())
+		}
+	}
+
+	// Deleting a record drops the links to it
+	expectStatus(t, api.do(testDoctor, http.MethodDelete, recordURL(labResult.ID), nil), http.StatusNoContent)
+	stored, err := api.stored(testDoctor, consultation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.RelatedRecordIDs) != 0 {
+		t.Errorf("related_record_ids = %v after delete, want none", stored.RelatedRecordIDs)
+	}
+}
+
+func TestSetRecordConfidentialWritesAudit(t *testing.T) {
+	api := newTestAPI(t)
+	record := api.seed(testDoctor, MedicalRecord{})
+
+	w := api.do(testNurse, http.MethodPatch, recordURL(record.ID)+"/confidential", map[string]interface{}{"is_confidential": false})
+	expectStatus(t, w, http.StatusForbidden)
+
+	w = api.do(testDoctor, http.MethodPatch, recordURL(record.ID)+"/confidential", map[string]interface{}{"is_confidential": false})
+	expectStatus(t, w, http.StatusOK)
+
+	if len(api.store.audit) != 1 || api.store.audit[0].PatientID != record.PatientID {
+		t.Fatalf("audit = %+v, want one entry for %s", api.store.audit, record.PatientID)
+	}
+	if revisions, _ := api.store.Revisions(testDoctor.context(), record.ID); len(revisions) != 1 {
+		t.Errorf("revisions = %d, want 1", len(revisions))
+	}
+}
+
+func TestRecordChangesIncludeDeletions(t *testing.T) {
+	api := newTestAPI(t)
+	start := time.Now().UTC().Add(-time.Minute)
+	kept := api.seed(testDoctor, MedicalRecord{UpdatedAt: start.Add(10 * time.Second)})
+	deleted := api.seed(testDoctor, MedicalRecord{UpdatedAt: start.Add(20 * time.Second)})
+	api.seed(testOtherClinic, MedicalRecord{UpdatedAt: start.Add(30 * time.Second)})
+
+	expectStatus(t, api.do(testDoctor, http.MethodDelete, recordURL(deleted.ID), nil), http.StatusNoContent)
+
+	since := url.QueryEscape(start.Format(time.RFC3339))
+	w := api.do(testDoctor, http.MethodGet, "/api/v1/medical-records/changes?since="+since, nil)
+	expectStatus(t, w, http.StatusOK)
+	changes := decodeBody[struct {
+		Changes []RecordChange `json:"changes"`
+	}](t, w).Changes
+
+	if len(changes) != 2 {
+		t.Fatalf("changes = %+v, want the kept record and the deletion", changes)
+	}
+	if changes[0].ID != kept.ID || changes[0].Deleted || changes[0].Record == nil {
+		t.Errorf("first change = %+v, want the kept record", changes[0])
+	}
+	if changes[1].ID != deleted.ID || !changes[1].Deleted {
+		t.Errorf("second change = %+v, want the deletion", changes[1])
+	}
+}
+
+func TestMergePatient(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     testCaller
+		target     []MedicalRecord
+		wantStatus int
+		wantMoved  bool
+	}{
+		{name: "moves records", caller: testAdmin, wantStatus: http.StatusOK, wantMoved: true},
+		{name: "admins only", caller: testDoctor, wantStatus: http.StatusForbidden},
+		{
+			name:       "same appointment record on both",
+			caller:     testAdmin,
+			target:     []MedicalRecord{{PatientID: "PAT-2", AppointmentID: "APT-1"}},
+			wantStatus: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			record := api.seed(testDoctor, MedicalRecord{AppointmentID: "APT-1"})
+			for _, target := range tt.target {
+				api.seed(testDoctor, target)
+			}
+
+			w := api.do(tt.caller, http.MethodPost, "/api/v1/patients/PAT-1/merge", map[string]interface{}{
+				"target_patient_id": "PAT-2",
+			})
+			expectStatus(t, w, tt.wantStatus)
+
+			stored, err := api.stored(testDoctor, record.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moved := stored.PatientID == "PAT-2"; moved != tt.wantMoved {
+				t.Errorf("patient_id = %s, moved = %v, want %v", stored.PatientID, moved, tt.wantMoved)
+			}
+		})
+	}
+}
+
+func TestSearchPrescriptionsReturnsMatchingPrescriptions(t *testing.T) {
+	api := newTestAPI(t)
+	api.seed(testDoctor, MedicalRecord{Prescriptions: []Prescription{
+		{MedicationName: "Amoxicillin", Dosage: "500mg", Frequency: "daily"},
+		{MedicationName: "Ibuprofen", Dosage: "200mg", Frequency: "daily"},
+	}})
+	api.seed(testDoctor, MedicalRecord{PatientID: "PAT-2", Prescriptions: []Prescription{
+		{MedicationName: "Co-amoxiclav", Dosage: "625mg", Frequency: "daily"},
+	}})
+	api.seed(testOtherClinic, MedicalRecord{Prescriptions: []Prescription{
+		{MedicationName: "Amoxicillin", Dosage: "500mg", Frequency: "daily"},
+	}})
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "partial", query: "medication=amox", want: []string{"Co-amoxiclav", "Amoxicillin"}},
+		{name: "prefix", query: "medication=amox&match=prefix", want: []string{"Amoxicillin"}},
+		{name: "patient", query: "medication=amox&patient_id=pat-2", want: []string{"Co-amoxiclav"}},
+		{name: "none", query: "medication=warfarin", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(testDoctor, http.MethodGet, "/api/v1/medical-records/search/prescriptions?"+tt.query, nil)
+			expectStatus(t, w, http.StatusOK)
+			response := decodeBody[struct {
+				Records []MedicalRecord `json:"records"`
+				Total   int             `json:"total"`
+			}](t, w)
+
+			var got []string
+			for _, record := range response.Records {
+				for _, prescription := range record.Prescriptions {
+					got = append(got, prescription.MedicationName)
+				}
+			}
+			if len(got) != len(tt.want) || response.Total != len(tt.want) {
+				t.Fatalf("medications = %v (total %d), want %v", got, response.Total, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("medications = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestExportPatientData(t *testing.T) {
+	api := newTestAPI(t)
+	first := api.seed(testDoctor, MedicalRecord{CreatedAt: time.Now().UTC().Add(-time.Hour)})
+	second := api.seed(testDoctor, MedicalRecord{})
+	api.seed(testDoctor, MedicalRecord{PatientID: "PAT-2"})
+
+	expectStatus(t, api.do(testDoctor, http.MethodGet, "/api/v1/patients/PAT-3/export.zip", nil), http.StatusNotFound)
+	expectStatus(t, api.do(testNurse, http.MethodGet, "/api/v1/patients/PAT-1/export.zip", nil), http.StatusForbidden)
+
+	w := api.do(testDoctor, http.MethodGet, "/api/v1/patients/PAT-1/export.zip", nil)
+	expectStatus(t, w, http.StatusOK)
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	want := []string{
+		"records/" + first.ID.Hex() + ".json",
+		"records/" + second.ID.Hex() + ".json",
+		"manifest.json",
+	}
+	if len(names) != len(want) {
+		t.Fatalf("archive files = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("archive files = %v, want %v", names, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactPHI controls whether identifiers and clinical search terms are
+// masked in log output. It is on unless LOG_REDACT_PHI=false.
+var redactPHI = true
+
+// phiParams are query and path parameters whose values may identify a
+// patient or reveal clinical details.
+var phiParams = map[string]bool{
+	"patient_id":        true,
+	"target_patient_id": true,
+	"medication":        true,
+	"name":              true,
+	"q":                 true,
+	"diagnosis_code":    true,
+}
+
+// redactValue replaces value with a short stable hash so log lines for the
+// same patient can still be correlated without exposing the identifier.
+func redactValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "redacted:" + hex.EncodeToString(sum[:6])
+}
+
+// redactedPath returns the request path and query for logging with PHI
+// path parameters and query values masked.
+func redactedPath(c *gin.Context) string {
+	path := c.Request.URL.Path
+	raw := c.Request.URL.RawQuery
+
+	if redactPHI {
+		for _, param := range c.Params {
+			if phiParams[param.Key] && param.Value != "" {
+				path = strings.Replace(path, "/"+param.Value, "/"+redactValue(param.Value), 1)
+			}
+		}
+
+		if raw != "" {
+			query := c.Request.URL.Query()
+			for key, values := range query {
+				if phiParams[key] {
+					for i, value := range values {
+						values[i] = redactValue(value)
+					}
+				}
+			}
+			raw = query.Encode()
+		}
+	}
+
+	if raw != "" {
+		path = path + "?" + raw
+	}
+	return path
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countersCollection holds named sequences used to number records.
+const countersCollection = "counters"
+
+// nextReferenceNumber returns the next human-readable record reference,
+// such as MR-2024-000123. Numbering restarts each year. Concurrent creates
+// never share a number; numbers of failed creates are skipped.
+func nextReferenceNumber(ctx context.Context, now time.Time) (string, error) {
+	return recordStore.NextReferenceNumber(ctx, now)
+}
+
+// getMedicalRecordByReference looks a record up by its reference number.
+//
+// @Summary Get a record by reference number
+// @Tags Records
+// @Produce json
+// @Param reference path string true "Reference number, such as MR-2024-000123"
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of a cached copy; ignored when If-None-Match is sent"
+// @Success 200 {object} MedicalRecord
+// @Success 304 "Not modified since the given ETag or date"
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/ref/{reference} [get]
+func getMedicalRecordByReference(c *gin.Context) {
+	reference := c.Param("reference")
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	records, err := findRecords(ctx, RecordQuery{RecordFilter: RecordFilter{ReferenceNumber: reference}, Limit: 1})
+	if err != nil {
+		logger.WithError(err).Error("Failed to fetch medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch record"})
+		return
+	}
+	if len(records) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+		return
+	}
+
+	record := records[0]
+	localizeRecord(&record, loc)
+	respondWithETag(c, record)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// durationPattern matches free-text prescription durations such as
+// "7 days", "2 weeks" or "3 months".
+var durationPattern = regexp.MustCompile(`^(\d+)\s*(day|week|month)s?$`)
+
+// prescriptionSpan returns how long p runs: the gap between its start and
+// end dates when both are set, otherwise its parsed Duration text. It
+// reports false when neither gives a length.
+func prescriptionSpan(p Prescription) (time.Duration, bool) {
+	if !p.StartDate.IsZero() && p.EndDate.After(p.StartDate) {
+		return p.EndDate.Sub(p.StartDate), true
+	}
+
+	match := durationPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(p.Duration)))
+	if match == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	days := map[string]int{"day": 1, "week": 7, "month": 30}[match[2]]
+	return time.Duration(n*days) * 24 * time.Hour, true
+}
+
+// renewedPrescription copies p for another course of the same length. The
+// new course starts when the original ends, or now if it already has; a
+// prescription without a known length is renewed open-ended.
+func renewedPrescription(p Prescription, now time.Time) Prescription {
+	renewed := p
+	renewed.PrescribedDate = now
+	renewed.StartDate = now
+	if p.EndDate.After(now) {
+		renewed.StartDate = p.EndDate
+	}
+	renewed.EndDate = time.Time{}
+	if span, ok := prescriptionSpan(p); ok {
+		renewed.EndDate = renewed.StartDate.Add(span)
+	}
+	renewed.Expired = false
+	return renewed
+}
+
+// renewPrescription appends a renewed copy of the prescription at :index to
+// the same record, so repeat prescriptions need not be re-entered.
+//
+// @Summary Renew a prescription
+// @Tags Records
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param index path int true "Position of the prescription in the record" minimum(0)
+// @Success 201 {object} object{record_id=string,index=int,prescription=Prescription}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record or prescription not found"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/prescriptions/{index}/renew [post]
+func renewPrescription(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription index"})
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	record, err := findRecord(ctx, objectID)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+			return
+		}
+		logger.WithError(err).Error("Failed to fetch medical record")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch record"})
+		return
+	}
+	if index >= len(record.Prescriptions) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
+		return
+	}
+
+	renewed := renewedPrescription(record.Prescriptions[index], time.Now().UTC())
+
+	updated, ok := appendRecordItem(c, objectID, func(record *MedicalRecord) {
+		record.Prescriptions = append(record.Prescriptions, renewed)
+	})
+	if !ok {
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"record_id":    objectID.Hex(),
+		"source_index": index,
+	}).Info("Prescription renewed")
+	c.JSON(http.StatusCreated, gin.H{
+		"record_id":    objectID.Hex(),
+		"index":        len(updated.Prescriptions) - 1,
+		"prescription": renewed,
+	})
+}
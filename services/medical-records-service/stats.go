@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MonthlyCount is the number of records created in one month.
+type MonthlyCount struct {
+	Month int   `json:"month"`
+	Count int64 `json:"count"`
+}
+
+// getRecordsByMonth counts the records created in each month of year,
+// optionally only those of one doctor. Months without records are
+// included with a zero count. Months follow UTC unless tz is given.
+//
+// @Summary Records created per month
+// @Tags Statistics
+// @Produce json
+// @Param year query int false "Calendar year, the current one by default"
+// @Param doctor_id query string false "Only records of this doctor"
+// @Param tz query string false "IANA time zone to render timestamps in, UTC by default"
+// @Success 200 {object} object{year=int,doctor_id=string,total=int,months=[]MonthlyCount}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/stats/records-by-month [get]
+func getRecordsByMonth(c *gin.Context) {
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	year := time.Now().In(loc).Year()
+	if value := c.Query("year"); value != "" {
+		year, err = strconv.Atoi(value)
+		if err != nil || year < 1 || year > 9999 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "year must be a valid year"})
+			return
+		}
+	}
+
+	// Creation times are stored to the millisecond, so the last one of the
+	// year bounds it inclusively
+	filter := RecordFilter{
+		DoctorID:    normalizeID(c.Query("doctor_id")),
+		CreatedFrom: time.Date(year, time.January, 1, 0, 0, 0, 0, loc),
+		CreatedTo:   time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc).Add(-time.Millisecond),
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	counts, err := recordStore.CountByMonth(ctx, filter, loc)
+	if err != nil {
+		logger.WithError(err).Error("Failed to aggregate records by month")
+		respondQueryError(c, err, "Failed to generate statistics")
+		return
+	}
+
+	months := make([]MonthlyCount, 12)
+	var total int64
+	for i := range months {
+		months[i] = MonthlyCount{Month: i + 1, Count: counts[i+1]}
+		total += counts[i+1]
+	}
+
+	response := gin.H{"year": year, "months": months, "total": total}
+	if filter.DoctorID != "" {
+		response["doctor_id"] = filter.DoctorID
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrRecordNotFound is returned by RecordStore lookups of a record or
+// revision that does not exist, or that belongs to another organization.
+var ErrRecordNotFound = errors.New("record not found")
+
+// ErrDuplicateRecord is returned when a write would give a patient two
+// records of the same type for one appointment.
+var ErrDuplicateRecord = errors.New("duplicate record")
+
+// ErrQueryTimeout is returned when a query was stopped by its time limit.
+var ErrQueryTimeout = errors.New("query exceeded the time limit")
+
+// SortField orders query results by one record field.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// RecordFilter selects records. Zero fields are ignored and the rest must
+// all match. IDs is only ignored when nil; an empty slice matches nothing.
+type RecordFilter struct {
+	IDs             []primitive.ObjectID
+	PatientID       string
+	DoctorID        string
+	AppointmentID   string
+	ReferenceNumber string
+	RecordTypes     []string
+	// The diagnosis fields must all match the same diagnosis
+	DiagnosisCode       string
+	DiagnosisSeverities []string
+	DiagnosisStatus     string
+	// Text matches title, description or a diagnosis description,
+	// literally and case-insensitively
+	Text string
+	// CreatedFrom and CreatedTo bound created_at inclusively
+	CreatedFrom      time.Time
+	CreatedTo        time.Time
+	HasPrescriptions bool
+	// AbnormalVitals matches records with a vital sign reading outside
+	// normalVitalRanges
+	AbnormalVitals  bool
+	LabTestCodes    []string
+	LabResultStatus string
+	// IncludeDeleted also matches records soft-deleted by a patient
+	// erasure, which are otherwise never returned
+	IncludeDeleted bool
+}
+
+// RecordQuery is a filtered, sorted page of records. Fields lists the
+// record fields to load, by bson name, with _id always loaded; nil loads
+// every field. A zero Limit loads all matching records.
+type RecordQuery struct {
+	RecordFilter
+	Sort   []SortField
+	Fields []string
+	Skip   int
+	Limit  int
+}
+
+// PrescriptionQuery finds records prescribing a medication, matched
+// case-insensitively anywhere in the name or, with Prefix, at its start.
+type PrescriptionQuery struct {
+	Medication string
+	Prefix     bool
+	PatientID  string
+	Skip       int
+	Limit      int
+}
+
+// PatientSummary aggregates a patient's records.
+type PatientSummary struct {
+	PatientID          string           `json:"_id"`
+	TotalRecords       int64            `json:"total_records"`
+	RecordTypes        []string         `json:"record_types"`
+	LatestRecord       time.Time        `json:"latest_record"`
+	TotalDiagnoses     int64            `json:"total_diagnoses"`
+	TotalPrescriptions int64            `json:"total_prescriptions"`
+	TotalLabResults    int64            `json:"total_lab_results"`
+	RecordsByType      map[string]int64 `json:"records_by_type"`
+	LabResultsByStatus map[string]int64 `json:"lab_results_by_status"`
+	CriticalLabTests   []string         `json:"critical_lab_tests"`
+	Truncated          bool             `json:"truncated"`
+}
+
+// RecordStore is the storage behind the record endpoints. Every method is
+// scoped to the organization in ctx. Calls made with the context passed to
+// a Transaction function join that transaction.
+type RecordStore interface {
+	// Transaction runs fn so that all of its writes commit or roll back
+	// together. fn must use the context it is given and may be retried,
+	// so it should reset any state it sets.
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// Create inserts record, stamping it with the caller's organization.
+	Create(ctx context.Context, record *MedicalRecord) error
+	// Get loads a single record by ID.
+	Get(ctx context.Context, id primitive.ObjectID) (MedicalRecord, error)
+	// List loads the records matching query from the primary. The result
+	// is never nil so it always encodes as a JSON array.
+	List(ctx context.Context, query RecordQuery) ([]MedicalRecord, error)
+	// Search is List with the analytics read preference, for listings
+	// that may briefly lag writes.
+	Search(ctx context.Context, query RecordQuery) ([]MedicalRecord, error)
+	// Each calls fn with each record matching query in turn, without
+	// loading them all at once. An error from fn stops the iteration and
+	// is returned.
+	Each(ctx context.Context, query RecordQuery, fn func(MedicalRecord) error) error
+	// Count counts the records matching filter with the analytics read
+	// preference.
+	Count(ctx context.Context, filter RecordFilter) (int64, error)
+	// Update loads a record, lets mutate change it and stores the result
+	// in its place, returning the record as it was and as stored. mutate
+	// is called again with the record as it now is whenever another write
+	// changed it first, so it must not keep state between calls. An error
+	// from mutate stores nothing and is returned as is.
+	Update(ctx context.Context, id primitive.ObjectID, mutate func(record *MedicalRecord) error) (previous, updated MedicalRecord, err error)
+	// Delete removes a record, and the links other records hold to it,
+	// and returns it as it was.
+	Delete(ctx context.Context, id primitive.ObjectID) (MedicalRecord, error)
+	// ReassignPatient moves every record of patient from to patient to on
+	// behalf of user and returns how many moved.
+	ReassignPatient(ctx context.Context, from, to, user string, at time.Time) (int64, error)
+	// MarkDeleted soft-deletes records on behalf of user: they stay stored
+	// but only IncludeDeleted filters match them.
+	MarkDeleted(ctx context.Context, ids []primitive.ObjectID, user string, at time.Time) error
+	// Purge removes records, soft-deleted or not, with their revisions.
+	Purge(ctx context.Context, ids []primitive.ObjectID) error
+
+	// SearchPrescriptions returns a page of records prescribing a
+	// medication, newest first, each carrying only the matching
+	// prescriptions, and the total number of such records.
+	SearchPrescriptions(ctx context.Context, query PrescriptionQuery) ([]MedicalRecord, int64, error)
+	// Summary aggregates a patient's records, limited to the most recent
+	// limit records when limit is positive, and gives up after timeout.
+	// A patient without records is ErrRecordNotFound.
+	Summary(ctx context.Context, patientID string, limit int, timeout time.Duration) (PatientSummary, error)
+	// CountByMonth counts the records matching filter by the month of loc
+	// they were created in, keyed 1 to 12.
+	CountByMonth(ctx context.Context, filter RecordFilter, loc *time.Location) (map[int]int64, error)
+	// Timeline returns up to limit dated events of a patient's records,
+	// newest first, only those before before unless it is zero.
+	Timeline(ctx context.Context, patientID string, before time.Time, limit int) ([]TimelineEvent, error)
+	// Changes returns up to limit record changes and deletions after the
+	// position, oldest first.
+	Changes(ctx context.Context, after changesCursor, limit int) ([]RecordChange, error)
+	// NextReferenceNumber returns the next record reference for the year
+	// of now. Numbers are never handed out twice.
+	NextReferenceNumber(ctx context.Context, now time.Time) (string, error)
+
+	// SaveRevision stores record as the next revision in its history.
+	SaveRevision(ctx context.Context, record MedicalRecord) error
+	// Revisions returns a record's revisions, oldest first.
+	Revisions(ctx context.Context, id primitive.ObjectID) ([]RecordRevision, error)
+	// Revision returns one revision of a record.
+	Revision(ctx context.Context, id primitive.ObjectID, rev int) (RecordRevision, error)
+	// WriteTombstone stores a tombstone for a deleted record, replacing any
+	// from an earlier deletion under the same ID.
+	WriteTombstone(ctx context.Context, tombstone RecordTombstone) error
+	// WriteAudit stores an audit entry.
+	WriteAudit(ctx context.Context, entry AuditEntry) error
+
+	// ClaimIdempotencyKey reserves key for recordID. If the key is already
+	// taken it returns the record ID it was claimed for and false.
+	ClaimIdempotencyKey(ctx context.Context, key string, recordID primitive.ObjectID) (primitive.ObjectID, bool, error)
+	// ReleaseIdempotencyKey frees a claimed key.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// recordStore is the RecordStore used by the handlers.
+var recordStore RecordStore = mongoRecordStore{}
+
+// findRecord loads a single record by ID from recordStore. It returns
+// ErrRecordNotFound when the record does not exist.
+func findRecord(ctx context.Context, id primitive.ObjectID) (MedicalRecord, error) {
+	return recordStore.Get(ctx, id)
+}
+
+// findRecords loads all records matching query from recordStore. The
+// result is never nil so it always encodes as a JSON array.
+func findRecords(ctx context.Context, query RecordQuery) ([]MedicalRecord, error) {
+	return recordStore.List(ctx, query)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errPrescriptionNotFound is returned when a prescription index is past the
+// end of the record's prescriptions.
+var errPrescriptionNotFound = errors.New("prescription not found")
+
+// errPrescriptionDates is returned for a prescription ending before it
+// starts.
+var errPrescriptionDates = errors.New("end_date must not be before start_date")
+
+// addDiagnosis appends a single diagnosis to a record without touching its
+// other fields.
+//
+// @Summary Add a diagnosis
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param diagnosis body Diagnosis true "The diagnosis"
+// @Success 200 {object} createRecordResponse
+// @Failure 400 {object} apiError "Invalid request, or a duplicate active code when DUPLICATE_DIAGNOSIS_MODE is reject"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/diagnoses [post]
+func addDiagnosis(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	var diagnosis Diagnosis
+	if err := c.ShouldBindJSON(&diagnosis); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if diagnosis.Status == "" {
+		diagnosis.Status = "active"
+	}
+	if diagnosis.DateDiagnosed.IsZero() {
+		diagnosis.DateDiagnosed = time.Now()
+	}
+	diagnosis.DateDiagnosed = diagnosis.DateDiagnosed.UTC()
+	if err := validate.Struct(&diagnosis); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	// Checked against the record the diagnosis is appended to, so a code
+	// appended concurrently counts. Only the appended code counts;
+	// duplicates already stored are left to the next full update.
+	var warnings []RecordWarning
+	updated, err := applyRecordUpdate(ctx, objectID, currentUser(c), func(record *MedicalRecord) error {
+		record.Diagnosis = append(record.Diagnosis, diagnosis)
+		var duplicates []string
+		for _, code := range duplicateDiagnosisCodes(record.Diagnosis) {
+			if code == strings.ToUpper(strings.TrimSpace(diagnosis.Code)) {
+				duplicates = append(duplicates, code)
+			}
+		}
+		var err error
+		warnings, err = checkDuplicateDiagnoses(duplicates)
+		return err
+	})
+	var dupErr *DuplicateDiagnosisError
+	if errors.As(err, &dupErr) {
+		respondDuplicateDiagnosis(c, dupErr)
+		return
+	}
+	if err != nil {
+		respondRecordUpdateError(c, err)
+		return
+	}
+
+	logger.WithField("record_id", objectID.Hex()).Info("Diagnosis added to medical record")
+	c.JSON(http.StatusOK, createRecordResponse{MedicalRecord: updated, Warnings: warnings})
+}
+
+// addPrescription appends a single prescription to a record.
+//
+// @Summary Add a prescription
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param prescription body Prescription true "The prescription"
+// @Success 200 {object} MedicalRecord
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/prescriptions [post]
+func addPrescription(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	var prescription Prescription
+	if err := c.ShouldBindJSON(&prescription); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if prescription.PrescribedDate.IsZero() {
+		prescription.PrescribedDate = time.Now()
+	}
+	prescription.PrescribedDate = prescription.PrescribedDate.UTC()
+	prescription.StartDate = prescription.StartDate.UTC()
+	prescription.EndDate = prescription.EndDate.UTC()
+	if err := validate.Struct(&prescription); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if !prescription.StartDate.IsZero() && !prescription.EndDate.IsZero() && prescription.EndDate.Before(prescription.StartDate) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "end_date must not be before start_date", "field": "end_date"})
+		return
+	}
+	prescription.Expired = prescriptionExpired(prescription, time.Now())
+
+	updated, ok := appendRecordItem(c, objectID, func(record *MedicalRecord) {
+		record.Prescriptions = append(record.Prescriptions, prescription)
+	})
+	if !ok {
+		return
+	}
+
+	logger.WithField("record_id", objectID.Hex()).Info("Prescription added to medical record")
+	c.JSON(http.StatusOK, updated)
+}
+
+// prescriptionPatch holds the prescription fields a PATCH may change. Nil
+// fields keep their stored value. The medication is not editable; a
+// different medication is a new prescription.
+type prescriptionPatch struct {
+	Dosage       *string    `json:"dosage"`
+	Frequency    *string    `json:"frequency"`
+	Duration     *string    `json:"duration"`
+	Instructions *string    `json:"instructions"`
+	StartDate    *time.Time `json:"start_date"`
+	EndDate      *time.Time `json:"end_date"`
+}
+
+// empty reports whether the patch changes nothing.
+func (p prescriptionPatch) empty() bool {
+	return p.Dosage == nil && p.Frequency == nil && p.Duration == nil &&
+		p.Instructions == nil && p.StartDate == nil && p.EndDate == nil
+}
+
+// apply returns prescription with the patch applied and its expiry
+// recomputed, failing when the result is not a valid prescription.
+func (p prescriptionPatch) apply(prescription Prescription, now time.Time) (Prescription, error) {
+	if p.Dosage != nil {
+		prescription.Dosage = *p.Dosage
+	}
+	if p.Frequency != nil {
+		prescription.Frequency = *p.Frequency
+	}
+	if p.Duration != nil {
+		prescription.Duration = *p.Duration
+	}
+	if p.Instructions != nil {
+		prescription.Instructions = *p.Instructions
+	}
+	if p.StartDate != nil {
+		prescription.StartDate = p.StartDate.UTC()
+	}
+	if p.EndDate != nil {
+		prescription.EndDate = p.EndDate.UTC()
+	}
+
+	if err := validate.Struct(&prescription); err != nil {
+		return prescription, err
+	}
+	if !prescription.StartDate.IsZero() && !prescription.EndDate.IsZero() && prescription.EndDate.Before(prescription.StartDate) {
+		return prescription, errPrescriptionDates
+	}
+	prescription.Expired = prescriptionExpired(prescription, now)
+	return prescription, nil
+}
+
+// updatePrescription changes the dosage, frequency, duration, instructions
+// or dates of the prescription at :index, so one medication can be adjusted
+// without resending the whole record. The prescription is revalidated as a
+// whole, a history revision is saved, and the updated record is returned.
+//
+// @Summary Update one prescription
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param index path int true "Position of the prescription in the record" minimum(0)
+// @Param changes body prescriptionPatch true "Fields to change; others keep their value"
+// @Success 200 {object} MedicalRecord
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record or prescription not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/prescriptions/{index} [patch]
+func updatePrescription(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescription index"})
+		return
+	}
+
+	var patch prescriptionPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if patch.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one prescription field must be given"})
+		return
+	}
+
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	updated, err := applyRecordUpdate(ctx, objectID, currentUser(c), func(record *MedicalRecord) error {
+		if index >= len(record.Prescriptions) {
+			return errPrescriptionNotFound
+		}
+		prescription, err := patch.apply(record.Prescriptions[index], time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		record.Prescriptions[index] = prescription
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errPrescriptionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
+		case errors.Is(err, errPrescriptionDates):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "field": "end_date"})
+		case errors.As(err, new(validator.ValidationErrors)):
+			respondValidationError(c, err)
+		default:
+			respondRecordUpdateError(c, err)
+		}
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"record_id": objectID.Hex(),
+		"index":     index,
+	}).Info("Prescription updated")
+	c.JSON(http.StatusOK, updated)
+}
+
+// labResultResponse is the updated record with a flag telling the caller
+// the appended result is critical and may need alerting.
+type labResultResponse struct {
+	MedicalRecord
+	Critical bool `json:"critical"`
+}
+
+// addLabResult appends a single lab result to a record, as fed by lab
+// systems once results are available.
+//
+// @Summary Add a lab result
+// @Tags Records
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param result body LabResult true "The lab result"
+// @Success 200 {object} labResultResponse
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 404 {object} apiError "Record not found"
+// @Failure 413 {object} apiError "Request body too large"
+// @Failure 422 {object} apiError "Failed validation"
+// @Failure 423 {object} apiError "Locked for editing by another user"
+// @Failure 500 {object} apiError "Internal error"
+// @Security BearerAuth
+// @Router /api/v1/medical-records/{id}/lab-results [post]
+func addLabResult(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	var labResult LabResult
+	if err := c.ShouldBindJSON(&labResult); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if labResult.TestDate.IsZero() {
+		labResult.TestDate = time.Now()
+	}
+	labResult.TestDate = labResult.TestDate.UTC()
+	typeLabResult(&labResult)
+	if err := validate.Struct(&labResult); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	updated, ok := appendRecordItem(c, objectID, func(record *MedicalRecord) {
+		record.LabResults = append(record.LabResults, labResult)
+	})
+	if !ok {
+		return
+	}
+
+	critical := labResult.Status == "critical"
+	if critical {
+		notifyCriticalLabResults(updated, []LabResult{labResult})
+	}
+	logger.WithFields(logrus.Fields{
+		"record_id": objectID.Hex(),
+		"critical":  critical,
+	}).Info("Lab result added to medical record")
+	c.JSON(http.StatusOK, labResultResponse{MedicalRecord: updated, Critical: critical})
+}
+
+// appendRecordItem lets add append an item to one of the record's arrays,
+// stamping the modification time and user. On failure it writes the error
+// response and returns false.
+func appendRecordItem(c *gin.Context, id primitive.ObjectID, add func(record *MedicalRecord)) (MedicalRecord, bool) {
+	ctx, cancel := dbWriteContext(c)
+	defer cancel()
+
+	updated, err := applyRecordUpdate(ctx, id, currentUser(c), func(record *MedicalRecord) error {
+		add(record)
+		return nil
+	})
+	if err != nil {
+		respondRecordUpdateError(c, err)
+		return MedicalRecord{}, false
+	}
+	return updated, true
+}
+
+// respondRecordUpdateError maps errors from applyRecordUpdate to responses.
+func respondRecordUpdateError(c *gin.Context, err error) {
+	if errors.Is(err, ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Medical record not found"})
+		return
+	}
+	var lockErr *RecordLockedError
+	if errors.As(err, &lockErr) {
+		respondRecordLocked(c, lockErr)
+		return
+	}
+	logger.WithError(err).Error("Failed to update medical record")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update record"})
+}
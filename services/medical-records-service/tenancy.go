@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// organizationHeader names the caller's organization when
+// organizationHeaderAllowed is set and the request has no organization
+// claim.
+const organizationHeader = "X-Organization-ID"
+
+// organizationHeaderAllowed lets organizationHeader stand in for the
+// token's organization_id claim (ALLOW_ORGANIZATION_HEADER). It is for
+// local development without tokens only: anyone can send the header.
+var organizationHeaderAllowed bool
+
+// contextOrganizationID is the context key set by organizationMiddleware.
+const contextOrganizationID = "organization_id"
+
+// organizationIDPattern is the format of organization IDs after
+// normalization.
+var organizationIDPattern = regexp.MustCompile(defaultIDPattern)
+
+// organizationMiddleware requires every request to act for one
+// organization (clinic), taken from the verified token's organization_id
+// claim, and stores it in the context. The X-Organization-ID header is
+// only trusted when organizationHeaderAllowed is set, and a header that
+// contradicts the token is refused, so a caller cannot switch clinics.
+func organizationMiddleware() gin.HandlerFunc {
+	if organizationHeaderAllowed {
+		logger.Warn("ALLOW_ORGANIZATION_HEADER is set, the organization is taken from a request header")
+	}
+	return func(c *gin.Context) {
+		orgID := c.GetString(contextOrganizationID)
+		header := normalizeID(c.GetHeader(organizationHeader))
+		switch {
+		case orgID == "" && organizationHeaderAllowed:
+			orgID = header
+		case orgID != "" && header != "" && header != orgID:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Organization does not match the token"})
+			return
+		}
+
+		if orgID == "" {
+			if currentUser(c) != "" {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token has no organization_id claim"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Organization is required as the token's organization_id claim"})
+			return
+		}
+		if !organizationIDPattern.MatchString(orgID) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		c.Set(contextOrganizationID, orgID)
+		c.Next()
+	}
+}
+
+// currentOrganization returns the organization the request acts for.
+func currentOrganization(c *gin.Context) string {
+	return c.GetString(contextOrganizationID)
+}
+
+type organizationKey struct{}
+
+// withOrganization returns a copy of ctx scoped to orgID. Database access
+// through scopedCollection only sees that organization's documents.
+func withOrganization(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, organizationKey{}, orgID)
+}
+
+// organizationFrom returns the organization ctx is scoped to, or "" when
+// it is not scoped, which matches no documents.
+func organizationFrom(ctx context.Context) string {
+	orgID, _ := ctx.Value(organizationKey{}).(string)
+	return orgID
+}
+
+// requestContext returns the parent context for a handler's database work,
+// scoped to the caller's organization. Like context.Background it is not
+// cancelled when the client goes away.
+func requestContext(c *gin.Context) context.Context {
+	return withOrganization(context.Background(), currentOrganization(c))
+}
+
+// scopedCollection wraps a collection so every filter and pipeline is
+// restricted to the organization of the context it runs with. Only the
+// operations handlers need are exposed, so an unscoped query cannot be
+// issued through it by mistake.
+type scopedCollection struct {
+	collection *mongo.Collection
+	field      string
+	// live also restricts queries to records not soft-deleted by a
+	// patient erasure
+	live bool
+}
+
+// recordsCollection returns the medical records collection scoped by
+// organization, without soft-deleted records.
+func recordsCollection() scopedCollection {
+	return scopedCollection{collection: db.Collection(recordsCollectionName), field: "organization_id", live: true}
+}
+
+// historyRecords returns the record history collection scoped by the
+// organization of each snapshot.
+func historyRecords() scopedCollection {
+	return scopedCollection{collection: db.Collection(historyCollection), field: "snapshot.organization_id"}
+}
+
+func (s scopedCollection) scope(ctx context.Context, filter bson.M) bson.M {
+	scoped := make(bson.M, len(filter)+1)
+	for key, value := range filter {
+		scoped[key] = value
+	}
+	scoped[s.field] = organizationFrom(ctx)
+	if s.live {
+		scoped["deleted_at"] = nil
+	}
+	return scoped
+}
+
+func (s scopedCollection) scopePipeline(ctx context.Context, pipeline []bson.M) []bson.M {
+	return append([]bson.M{{"$match": s.scope(ctx, bson.M{})}}, pipeline...)
+}
+
+func (s scopedCollection) Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return s.collection.Find(ctx, s.scope(ctx, filter), opts...)
+}
+
+func (s scopedCollection) FindOne(ctx context.Context, filter bson.M, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return s.collection.FindOne(ctx, s.scope(ctx, filter), opts...)
+}
+
+func (s scopedCollection) FindOneAndUpdate(ctx context.Context, filter bson.M, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return s.collection.FindOneAndUpdate(ctx, s.scope(ctx, filter), update, opts...)
+}
+
+func (s scopedCollection) FindOneAndDelete(ctx context.Context, filter bson.M, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	return s.collection.FindOneAndDelete(ctx, s.scope(ctx, filter), opts...)
+}
+
+func (s scopedCollection) ReplaceOne(ctx context.Context, filter bson.M, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	return s.collection.ReplaceOne(ctx, s.scope(ctx, filter), replacement, opts...)
+}
+
+func (s scopedCollection) UpdateMany(ctx context.Context, filter bson.M, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return s.collection.UpdateMany(ctx, s.scope(ctx, filter), update, opts...)
+}
+
+func (s scopedCollection) DeleteMany(ctx context.Context, filter bson.M, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return s.collection.DeleteMany(ctx, s.scope(ctx, filter), opts...)
+}
+
+func (s scopedCollection) CountDocuments(ctx context.Context, filter bson.M, opts ...*options.CountOptions) (int64, error) {
+	return s.collection.CountDocuments(ctx, s.scope(ctx, filter), opts...)
+}
+
+func (s scopedCollection) Aggregate(ctx context.Context, pipeline []bson.M, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	return s.collection.Aggregate(ctx, s.scopePipeline(ctx, pipeline), opts...)
+}
+
+// insertRecord stores a new record in the organization of ctx.
+func insertRecord(ctx context.Context, record *MedicalRecord) error {
+	record.OrganizationID = organizationFrom(ctx)
+	_, err := db.Collection(recordsCollectionName).InsertOne(ctx, record)
+	return err
+}
+
+// backfillOrganization assigns records, history and tombstones stored
+// before organizations were introduced to DEFAULT_ORGANIZATION_ID, so an
+// existing single-clinic deployment keeps its data visible. Without it
+// such documents belong to no organization and are never returned.
+func backfillOrganization() {
+	orgID := normalizeID(os.Getenv("DEFAULT_ORGANIZATION_ID"))
+	if orgID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	for name, field := range map[string]string{
+		recordsCollectionName: "organization_id",
+		historyCollection:     "snapshot.organization_id",
+		tombstoneCollection:   "organization_id",
+	} {
+		result, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{field: bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{field: orgID}},
+		)
+		if err != nil {
+			logger.WithError(err).WithField("collection", name).Error("Failed to backfill organization")
+			continue
+		}
+		if result.ModifiedCount > 0 {
+			logger.WithField("collection", name).Infof("Assigned %d documents to organization %s", result.ModifiedCount, orgID)
+		}
+	}
+}
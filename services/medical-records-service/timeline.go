@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TimelineEvent is one entry in a patient's clinical timeline.
+type TimelineEvent struct {
+	Type     string             `bson:"type" json:"type"`
+	Date     time.Time          `bson:"date" json:"date"`
+	Summary  string             `bson:"summary" json:"summary"`
+	RecordID primitive.ObjectID `bson:"record_id" json:"record_id"`
+}
+
+// getPatientTimeline returns a patient's diagnoses, prescriptions, lab
+// results and record creations as one feed, newest first. It pages by date:
+// pass the returned next_before as before to get the following page.
+//
+// @Summary A patient's events, newest first
+// @Tags Patients
+// @Produce json
+// @Param patient_id path string true "Patient ID"
+// @Param before query string false "Only events before this time (RFC 3339 or YYYY-MM-DD)"
+// @Param limit query int false "Events per page" minimum(1)
+// @Success 200 {object} object{patient_id=string,limit=int,has_more=bool,events=[]TimelineEvent,next_before=string}
+// @Failure 400 {object} apiError "Invalid request"
+// @Failure 401 {object} apiError "Missing or invalid token"
+// @Failure 500 {object} apiError "Internal error"
+// @Failure 503 {object} apiError "The query exceeded its time limit"
+// @Security BearerAuth
+// @Router /api/v1/patients/{patient_id}/timeline [get]
+func getPatientTimeline(c *gin.Context) {
+	patientID := normalizeID(c.Param("patient_id"))
+
+	limit := defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	var before time.Time
+	if value := c.Query("before"); value != "" {
+		var err error
+		before, err = parseDateParam(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before: " + value})
+			return
+		}
+	}
+
+	ctx, cancel := dbReadContext(c)
+	defer cancel()
+
+	// One extra event tells whether another page follows
+	events, err := recordStore.Timeline(ctx, patientID, before, limit+1)
+	if err != nil {
+		logger.WithError(err).Error("Failed to aggregate patient timeline")
+		respondQueryError(c, err, "Failed to build timeline")
+		return
+	}
+
+	response := gin.H{"patient_id": patientID, "limit": limit, "has_more": false}
+	if len(events) > limit {
+		events, response["next_before"] = timelinePage(events, limit)
+		response["has_more"] = true
+	}
+	response["events"] = events
+
+	c.JSON(http.StatusOK, response)
+}
+
+// timelinePage cuts events, sorted newest first and holding one more than
+// limit, to a page and returns the before value for the next page. Events
+// sharing the boundary date are moved to the next page together so none
+// are skipped; dates are stored with millisecond precision, so the cursor
+// is the boundary plus one millisecond.
+func timelinePage(events []TimelineEvent, limit int) ([]TimelineEvent, time.Time) {
+	boundary := events[limit].Date
+	end := limit
+	for end > 0 && events[end-1].Date.Equal(boundary) {
+		end--
+	}
+	if end == 0 {
+		// More than a full page at one instant; page past it
+		return events[:limit], boundary
+	}
+	return events[:end], boundary.Add(time.Millisecond)
+}
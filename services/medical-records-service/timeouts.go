@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// HTTP server timeouts (READ_TIMEOUT, WRITE_TIMEOUT, IDLE_TIMEOUT,
+// READ_HEADER_TIMEOUT). Zero disables a timeout; an unset idle or header
+// timeout falls back to the read timeout, as in net/http.
+var (
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       time.Duration
+	readHeaderTimeout time.Duration
+
+	// streamWriteTimeout replaces writeTimeout on routes that stream files,
+	// such as attachment downloads and patient exports, which can outlast
+	// it (STREAM_WRITE_TIMEOUT). Zero means no write deadline at all.
+	streamWriteTimeout time.Duration
+
+	// Time limits on the database work of one request (DB_READ_TIMEOUT,
+	// DB_WRITE_TIMEOUT). Reads should allow for queryMaxTime, the
+	// server-side limit on listings.
+	dbReadTimeout  = 30 * time.Second
+	dbWriteTimeout = 30 * time.Second
+
+	// exportTimeout limits a whole patient export, which streams every
+	// attachment of the patient (EXPORT_TIMEOUT).
+	exportTimeout = 30 * time.Minute
+
+	// jobTimeout limits each run of a bulk background job, such as the
+	// prescription expiry sweep or the organization backfill at startup
+	// (JOB_TIMEOUT).
+	jobTimeout = 5 * time.Minute
+)
+
+// loadServerTimeouts reads the HTTP server timeouts from the environment.
+func loadServerTimeouts() {
+	readTimeout = getEnvDuration("READ_TIMEOUT", 30*time.Second)
+	writeTimeout = getEnvDuration("WRITE_TIMEOUT", 30*time.Second)
+	idleTimeout = getEnvDuration("IDLE_TIMEOUT", 0)
+	readHeaderTimeout = getEnvDuration("READ_HEADER_TIMEOUT", 0)
+	streamWriteTimeout = getEnvDuration("STREAM_WRITE_TIMEOUT", 0)
+	dbReadTimeout = getEnvDuration("DB_READ_TIMEOUT", 30*time.Second)
+	dbWriteTimeout = getEnvDuration("DB_WRITE_TIMEOUT", 30*time.Second)
+	exportTimeout = getEnvDuration("EXPORT_TIMEOUT", 30*time.Minute)
+	jobTimeout = getEnvDuration("JOB_TIMEOUT", 5*time.Minute)
+}
+
+// logTimeouts logs the effective server and database timeouts.
+func logTimeouts() {
+	logger.WithFields(logrus.Fields{
+		"read_timeout":         readTimeout.String(),
+		"write_timeout":        writeTimeout.String(),
+		"idle_timeout":         idleTimeout.String(),
+		"read_header_timeout":  readHeaderTimeout.String(),
+		"stream_write_timeout": streamWriteTimeout.String(),
+		"db_read_timeout":      dbReadTimeout.String(),
+		"db_write_timeout":     dbWriteTimeout.String(),
+		"query_max_time":       queryMaxTime.String(),
+		"export_timeout":       exportTimeout.String(),
+		"job_timeout":          jobTimeout.String(),
+	}).Info("Timeouts configured")
+}
+
+// dbReadContext returns the request's context, carrying its organization,
+// limited to dbReadTimeout for handlers that only read.
+func dbReadContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(requestContext(c), dbReadTimeout)
+}
+
+// dbWriteContext is dbReadContext limited to dbWriteTimeout, for handlers
+// that write.
+func dbWriteContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(requestContext(c), dbWriteTimeout)
+}
+
+// exportContext returns the request's context, carrying its organization,
+// limited to exportTimeout. Unlike dbReadContext it is cancelled when the
+// client goes away, so an abandoned export stops reading.
+func exportContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(withOrganization(c.Request.Context(), currentOrganization(c)), exportTimeout)
+}
+
+// newServer returns the HTTP server for handler with the configured
+// timeouts.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
+
+// streamingWriteDeadline lifts the server write timeout for the current
+// request, replacing it with streamWriteTimeout. It must run before the
+// handler starts writing.
+func streamingWriteDeadline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var deadline time.Time
+		if streamWriteTimeout > 0 {
+			deadline = time.Now().Add(streamWriteTimeout)
+		}
+		err := http.NewResponseController(c.Writer).SetWriteDeadline(deadline)
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			logger.WithError(err).Warn("Failed to extend write deadline for streaming response")
+		}
+		c.Next()
+	}
+}
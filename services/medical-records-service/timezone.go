@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// The runtime image is built FROM scratch and ships no zoneinfo
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTimezone reads the optional tz query parameter as an IANA zone name.
+// It returns nil when tz is absent, meaning timestamps stay in UTC.
+func parseTimezone(c *gin.Context) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tz: %s", name)
+	}
+	return loc, nil
+}
+
+// normalizeRecordTimes converts every timestamp in record to UTC so stored
+// and echoed values never carry the client's or server's local offset.
+func normalizeRecordTimes(record *MedicalRecord) {
+	record.CreatedAt = record.CreatedAt.UTC()
+	record.UpdatedAt = record.UpdatedAt.UTC()
+	for i := range record.Diagnosis {
+		record.Diagnosis[i].DateDiagnosed = record.Diagnosis[i].DateDiagnosed.UTC()
+	}
+	for i := range record.Prescriptions {
+		p := &record.Prescriptions[i]
+		p.PrescribedDate = p.PrescribedDate.UTC()
+		p.StartDate = p.StartDate.UTC()
+		p.EndDate = p.EndDate.UTC()
+	}
+	for i := range record.LabResults {
+		record.LabResults[i].TestDate = record.LabResults[i].TestDate.UTC()
+	}
+}
+
+// localizeRecord converts record's created_at and updated_at to loc for
+// the response. A nil loc leaves them in UTC.
+func localizeRecord(record *MedicalRecord, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	record.CreatedAt = record.CreatedAt.In(loc)
+	record.UpdatedAt = record.UpdatedAt.In(loc)
+}
+
+// localizeRecords applies localizeRecord to each record.
+func localizeRecords(records []MedicalRecord, loc *time.Location) {
+	for i := range records {
+		localizeRecord(&records[i], loc)
+	}
+}
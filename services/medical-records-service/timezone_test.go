@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseTimezone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{name: "absent", query: "", want: ""},
+		{name: "utc", query: "?tz=UTC", want: "UTC"},
+		{name: "iana zone", query: "?tz=Asia/Kolkata", want: "Asia/Kolkata"},
+		{name: "unknown zone", query: "?tz=Mars/Olympus", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/medical-records"+tt.query, nil)
+
+			loc, err := parseTimezone(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTimezone() = %v, want error", loc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTimezone() error = %v", err)
+			}
+			got := ""
+			if loc != nil {
+				got = loc.String()
+			}
+			if got != tt.want {
+				t.Errorf("parseTimezone() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecordTimesStoresUTC(t *testing.T) {
+	offset := time.FixedZone("UTC-5", -5*60*60)
+	record := MedicalRecord{
+		CreatedAt:  time.Date(2024, 3, 1, 10, 0, 0, 0, offset),
+		UpdatedAt:  time.Date(2024, 3, 1, 11, 0, 0, 0, offset),
+		LabResults: []LabResult{{TestDate: time.Date(2024, 2, 28, 23, 0, 0, 0, offset)}},
+	}
+
+	normalizeRecordTimes(&record)
+
+	for name, got := range map[string]time.Time{
+		"created_at": record.CreatedAt,
+		"updated_at": record.UpdatedAt,
+		"test_date":  record.LabResults[0].TestDate,
+	} {
+		if got.Location() != time.UTC {
+			t.Errorf("%s location = %v, want UTC", name, got.Location())
+		}
+	}
+	if want := time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC); !record.CreatedAt.Equal(want) {
+		t.Errorf("created_at = %v, want %v", record.CreatedAt, want)
+	}
+}
+
+func TestLocalizeRecordConvertsOffset(t *testing.T) {
+	stored := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		tz   string
+		want string
+	}{
+		{tz: "", want: "2024-03-01T10:00:00Z"},
+		{tz: "Asia/Kolkata", want: "2024-03-01T15:30:00+05:30"},
+		{tz: "America/New_York", want: "2024-03-01T05:00:00-05:00"},
+		{tz: "Australia/Sydney", want: "2024-03-01T21:00:00+11:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tz, func(t *testing.T) {
+			var loc *time.Location
+			if tt.tz != "" {
+				var err error
+				if loc, err = time.LoadLocation(tt.tz); err != nil {
+					t.Fatalf("LoadLocation(%q) error = %v", tt.tz, err)
+				}
+			}
+			record := MedicalRecord{CreatedAt: stored, UpdatedAt: stored}
+
+			localizeRecord(&record, loc)
+
+			body, err := json.Marshal(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				CreatedAt string `json:"created_at"`
+				UpdatedAt string `json:"updated_at"`
+			}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.CreatedAt != tt.want || got.UpdatedAt != tt.want {
+				t.Errorf("created_at, updated_at = %s, %s, want %s", got.CreatedAt, got.UpdatedAt, tt.want)
+			}
+			if !record.CreatedAt.Equal(stored) {
+				t.Errorf("created_at moved to %v, want the same instant as %v", record.CreatedAt, stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transactionsSupported is set at startup when the deployment is a replica
+// set or sharded cluster. Standalone servers do not support transactions.
+var transactionsSupported bool
+
+// detectTransactionSupport inspects the hello response to decide whether
+// multi-document transactions are available.
+func detectTransactionSupport(ctx context.Context, client *mongo.Client) bool {
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		logger.WithError(err).Warn("Failed to detect MongoDB topology, transactions disabled")
+		return false
+	}
+
+	_, isReplicaSet := hello["setName"]
+	isMongos := hello["msg"] == "isdbgrid"
+	return isReplicaSet || isMongos
+}
+
+// runInTransaction runs fn in a recordStore transaction so that all of its
+// writes commit or roll back together. fn must use the context it is given
+// for every operation. On a standalone server fn runs without a
+// transaction.
+func runInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return recordStore.Transaction(ctx, fn)
+}
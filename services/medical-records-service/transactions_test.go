@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// failingAuditStore is a memRecordStore whose audit writes always fail.
+type failingAuditStore struct {
+	*memRecordStore
+}
+
+func (failingAuditStore) WriteAudit(ctx context.Context, entry AuditEntry) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestAuditFailureRollsBackWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller testCaller
+		method string
+		path   func(record MedicalRecord) string
+		body   map[string]interface{}
+	}{
+		{
+			name:   "confidentiality change",
+			caller: testDoctor,
+			method: http.MethodPatch,
+			path:   func(record MedicalRecord) string { return recordURL(record.ID) + "/confidential" },
+			body:   map[string]interface{}{"is_confidential": false},
+		},
+		{
+			name:   "patient merge",
+			caller: testAdmin,
+			method: http.MethodPost,
+			path:   func(MedicalRecord) string { return "/api/v1/patients/PAT-1/merge" },
+			body:   map[string]interface{}{"target_patient_id": "PAT-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			record := api.seed(testDoctor, MedicalRecord{IsConfidential: true})
+			recordStore = failingAuditStore{api.store}
+
+			w := api.do(tt.caller, tt.method, tt.path(record), tt.body)
+			expectStatus(t, w, http.StatusInternalServerError)
+
+			stored, err := api.stored(testDoctor, record.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored.IsConfidential || stored.PatientID != "PAT-1" || !stored.UpdatedAt.Equal(record.UpdatedAt) {
+				t.Errorf("record = confidential %v, patient %s, updated %v; want it unchanged",
+					stored.IsConfidential, stored.PatientID, stored.UpdatedAt)
+			}
+			if revisions, _ := api.store.Revisions(testDoctor.context(), record.ID); len(revisions) != 0 {
+				t.Errorf("revisions = %d, want 0", len(revisions))
+			}
+		})
+	}
+}
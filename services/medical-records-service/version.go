@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.gitCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	gitCommit = "unknown"
+	buildTime = "unknown"
+)
+
+// buildInfo describes the running build.
+func buildInfo() gin.H {
+	return gin.H{
+		"version":    version,
+		"git_commit": gitCommit,
+		"build_time": buildTime,
+		"go_version": runtime.Version(),
+	}
+}
+
+// versionHandler reports which build is deployed.
+//
+// @Summary Build information
+// @Tags Monitoring
+// @Produce json
+// @Success 200 {object} object{version=string,git_commit=string,build_time=string,go_version=string}
+// @Router /version [get]
+func versionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, buildInfo())
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// vitalRange is an inclusive physiological range for a vital sign.
+type vitalRange struct {
+	Min float64
+	Max float64
+}
+
+// vitalRanges holds the accepted bounds per vital sign, keyed by JSON field
+// name. Each can be overridden with VITAL_RANGE_<FIELD>=min-max, for example
+// VITAL_RANGE_HEART_RATE=30-250. Temperature accepts both Celsius and
+// Fahrenheit readings.
+var vitalRanges = map[string]vitalRange{
+	"blood_pressure_systolic":  {40, 300},
+	"blood_pressure_diastolic": {20, 200},
+	"heart_rate":               {20, 300},
+	"temperature":              {25, 115},
+	"respiratory_rate":         {4, 80},
+	"oxygen_saturation":        {50, 100},
+}
+
+// normalVitalRanges holds the clinically normal range per vital sign, used
+// to flag abnormal readings rather than reject them. Each can be overridden
+// with VITAL_NORMAL_<FIELD>=min-max. Temperature is in Celsius; readings
+// above fahrenheitThreshold are converted first.
+var normalVitalRanges = map[string]vitalRange{
+	"blood_pressure_systolic":  {90, 140},
+	"blood_pressure_diastolic": {60, 90},
+	"heart_rate":               {60, 100},
+	"temperature":              {36.1, 38},
+	"respiratory_rate":         {12, 20},
+	"oxygen_saturation":        {94, 100},
+}
+
+// fahrenheitThreshold separates Fahrenheit temperature readings from
+// Celsius ones, since no living patient is at 50°C or 50°F.
+const fahrenheitThreshold = 50
+
+// VitalRangeError reports a vital sign outside its physiological range.
+type VitalRangeError struct {
+	Field string
+	Value float64
+	Range vitalRange
+}
+
+func (e *VitalRangeError) Error() string {
+	return fmt.Sprintf("vital_signs.%s value %g is outside the allowed range %g-%g", e.Field, e.Value, e.Range.Min, e.Range.Max)
+}
+
+// loadVitalRanges applies VITAL_RANGE_* and VITAL_NORMAL_* overrides from
+// the environment.
+func loadVitalRanges() {
+	loadVitalRangeOverrides("VITAL_RANGE_", vitalRanges)
+	loadVitalRangeOverrides("VITAL_NORMAL_", normalVitalRanges)
+}
+
+// loadVitalRangeOverrides replaces entries of ranges from <prefix><FIELD>
+// variables.
+func loadVitalRangeOverrides(prefix string, ranges map[string]vitalRange) {
+	for field, current := range ranges {
+		key := prefix + strings.ToUpper(field)
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := parseVitalRange(value)
+		if err != nil {
+			logger.WithField("key", key).Warnf("Invalid vital range %q, using default %g-%g", value, current.Min, current.Max)
+			continue
+		}
+		ranges[field] = parsed
+	}
+}
+
+func parseVitalRange(value string) (vitalRange, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return vitalRange{}, fmt.Errorf("expected min-max")
+	}
+	min, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return vitalRange{}, err
+	}
+	max, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return vitalRange{}, err
+	}
+	if min > max {
+		return vitalRange{}, fmt.Errorf("min is greater than max")
+	}
+	return vitalRange{Min: min, Max: max}, nil
+}
+
+// vitalValue is one vital sign reading keyed by its JSON field name.
+type vitalValue struct {
+	field string
+	value float64
+}
+
+// vitalValues returns the range-checked vital signs of v. A zero value
+// means the sign was not measured.
+func vitalValues(v *VitalSigns) []vitalValue {
+	return []vitalValue{
+		{"blood_pressure_systolic", float64(v.BloodPressureSystolic)},
+		{"blood_pressure_diastolic", float64(v.BloodPressureDiastolic)},
+		{"heart_rate", float64(v.HeartRate)},
+		{"temperature", v.Temperature},
+		{"respiratory_rate", float64(v.RespiratoryRate)},
+		{"oxygen_saturation", float64(v.OxygenSaturation)},
+	}
+}
+
+// validateVitalSigns checks each measured vital sign against its range. A
+// zero value means the sign was not measured and is skipped.
+func validateVitalSigns(v *VitalSigns) error {
+	if v == nil {
+		return nil
+	}
+
+	for _, vital := range vitalValues(v) {
+		if vital.value == 0 {
+			continue
+		}
+		r := vitalRanges[vital.field]
+		if vital.value < r.Min || vital.value > r.Max {
+			return &VitalRangeError{Field: vital.field, Value: vital.value, Range: r}
+		}
+	}
+	return nil
+}
+
+// computeBMI fills in BMI from weight in kilograms and height in
+// centimetres when both were measured and the client did not send one.
+func computeBMI(v *VitalSigns) {
+	if v == nil || v.BMI != 0 || v.Weight <= 0 || v.Height <= 0 {
+		return
+	}
+	meters := v.Height / 100
+	v.BMI = math.Round(v.Weight/(meters*meters)*10) / 10
+}
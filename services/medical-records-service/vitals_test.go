@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestValidateVitalSigns(t *testing.T) {
+	tests := []struct {
+		name      string
+		vitals    *VitalSigns
+		wantField string
+		wantValue float64
+	}{
+		{name: "not measured", vitals: nil},
+		{name: "all zero", vitals: &VitalSigns{}},
+		{name: "heart rate at min", vitals: &VitalSigns{HeartRate: 20}},
+		{name: "heart rate at max", vitals: &VitalSigns{HeartRate: 300}},
+		{name: "heart rate below min", vitals: &VitalSigns{HeartRate: 19}, wantField: "heart_rate", wantValue: 19},
+		{name: "heart rate above max", vitals: &VitalSigns{HeartRate: 301}, wantField: "heart_rate", wantValue: 301},
+		{name: "oxygen saturation at max", vitals: &VitalSigns{OxygenSaturation: 100}},
+		{name: "oxygen saturation above max", vitals: &VitalSigns{OxygenSaturation: 101}, wantField: "oxygen_saturation", wantValue: 101},
+		{name: "oxygen saturation below min", vitals: &VitalSigns{OxygenSaturation: 49}, wantField: "oxygen_saturation", wantValue: 49},
+		{name: "systolic at min", vitals: &VitalSigns{BloodPressureSystolic: 40}},
+		{name: "systolic below min", vitals: &VitalSigns{BloodPressureSystolic: 39}, wantField: "blood_pressure_systolic", wantValue: 39},
+		{name: "negative temperature", vitals: &VitalSigns{Temperature: -1}, wantField: "temperature", wantValue: -1},
+		{name: "fahrenheit temperature", vitals: &VitalSigns{Temperature: 98.6}},
+		{name: "partially measured", vitals: &VitalSigns{HeartRate: 72, RespiratoryRate: 16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVitalSigns(tt.vitals)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("validateVitalSigns() error = %v", err)
+				}
+				return
+			}
+			var rangeErr *VitalRangeError
+			if !errors.As(err, &rangeErr) {
+				t.Fatalf("validateVitalSigns() error = %v, want a VitalRangeError", err)
+			}
+			if rangeErr.Field != tt.wantField || rangeErr.Value != tt.wantValue {
+				t.Errorf("error for %s = %g, want %s = %g", rangeErr.Field, rangeErr.Value, tt.wantField, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestCreateMedicalRecordRejectsOutOfRangeVitals(t *testing.T) {
+	api := newTestAPI(t)
+
+	body := recordUpdate("Checkup")
+	body["vital_signs"] = map[string]interface{}{"heart_rate": 9000, "temperature": 36.6}
+	w := api.do(testDoctor, http.MethodPost, "/api/v1/medical-records", body)
+	expectStatus(t, w, http.StatusUnprocessableEntity)
+	got := decodeBody[map[string]interface{}](t, w)
+	if got["field"] != "vital_signs.heart_rate" || got["value"] != float64(9000) {
+		t.Errorf("error = %v, want field vital_signs.heart_rate with value 9000", got)
+	}
+	if count, _ := api.store.Count(testDoctor.context(), RecordFilter{}); count != 0 {
+		t.Errorf("stored records = %d, want 0", count)
+	}
+}
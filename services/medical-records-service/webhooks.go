@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// webhookConfig controls delivery of critical lab result events to the
+// URLs in CRITICAL_LAB_WEBHOOK_URLS.
+type webhookConfig struct {
+	URLs       []string
+	Timeout    time.Duration
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+var webhooks webhookConfig
+
+// criticalLabEvent is the payload posted to webhooks.
+type criticalLabEvent struct {
+	Event      string    `json:"event"`
+	RecordID   string    `json:"record_id"`
+	PatientID  string    `json:"patient_id"`
+	DoctorID   string    `json:"doctor_id"`
+	LabResult  LabResult `json:"lab_result"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+var webhookClient = &http.Client{}
+
+func loadWebhookConfig() webhookConfig {
+	var urls []string
+	for _, url := range strings.Split(os.Getenv("CRITICAL_LAB_WEBHOOK_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	return webhookConfig{
+		URLs:       urls,
+		Timeout:    getEnvDuration("WEBHOOK_TIMEOUT", 5*time.Second),
+		MaxRetries: getEnvInt("WEBHOOK_MAX_RETRIES", 3),
+		Backoff:    getEnvDuration("WEBHOOK_RETRY_BACKOFF", time.Second),
+	}
+}
+
+// notifyCriticalLabResults sends an alert for each critical result to every
+// alert channel. Slow channels deliver in the background so the API
+// response is never delayed.
+func notifyCriticalLabResults(record MedicalRecord, results []LabResult) {
+	for _, result := range results {
+		if result.Status != "critical" {
+			continue
+		}
+
+		event := criticalLabEvent{
+			Event:      "critical_lab_result",
+			RecordID:   record.ID.Hex(),
+			PatientID:  record.PatientID,
+			DoctorID:   record.DoctorID,
+			LabResult:  result,
+			OccurredAt: time.Now().UTC(),
+		}
+		for _, channel := range alertChannels {
+			channel.Send(event)
+		}
+	}
+}
+
+// deliverWebhook posts payload to url, retrying failures with a growing
+// backoff up to the configured retry limit. Retries stop once shutdown
+// begins.
+func deliverWebhook(url string, payload []byte) {
+	backoff := webhooks.Backoff
+	for attempt := 0; ; attempt++ {
+		err := postWebhook(url, payload)
+		if err == nil {
+			return
+		}
+
+		log := logger.WithError(err).WithFields(logrus.Fields{"url": url, "attempt": attempt + 1})
+		if attempt >= webhooks.MaxRetries {
+			log.Error("Giving up delivering critical lab result webhook")
+			return
+		}
+		log.Warn("Failed to deliver critical lab result webhook, retrying")
+
+		select {
+		case <-backgroundCtx.Done():
+			log.Error("Shutting down before webhook could be delivered")
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func postWebhook(url string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhooks.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
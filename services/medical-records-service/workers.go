@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// backgroundWorkers tracks goroutines doing work outside the request
+	// cycle so shutdown can wait for them before disconnecting from MongoDB.
+	backgroundWorkers sync.WaitGroup
+
+	// backgroundCtx is cancelled when shutdown starts. Long-running workers
+	// should return once it is done.
+	backgroundCtx, stopBackground = context.WithCancel(context.Background())
+)
+
+// goBackground runs fn in a goroutine that shutdown waits for.
+func goBackground(fn func()) {
+	backgroundWorkers.Add(1)
+	go func() {
+		defer backgroundWorkers.Done()
+		fn()
+	}()
+}
+
+// waitForBackground blocks until all background workers have finished or
+// ctx expires. It reports whether every worker finished.
+func waitForBackground(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		backgroundWorkers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shutdown stops server gracefully once a shutdown signal has arrived.
+// Readiness fails first so the load balancer stops routing new traffic
+// here, optionally waiting SHUTDOWN_DRAIN_DELAY for it to notice, then
+// in-flight requests and background workers drain before disconnect closes
+// the database connection. It returns the error if the server could not
+// be shut down within SHUTDOWN_TIMEOUT, without disconnecting.
+func shutdown(server *http.Server, disconnect func(context.Context) error) error {
+	shuttingDown.Store(true)
+	logger.WithField("in_flight", inFlightRequests.Load()).Info("Shutting down server...")
+	if delay := getEnvDuration("SHUTDOWN_DRAIN_DELAY", 0); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	// Let background work drain before the database goes away
+	stopBackground()
+	if !waitForBackground(ctx) {
+		logger.Warn("Timed out waiting for background workers to finish")
+	}
+
+	if err := disconnect(ctx); err != nil {
+		logger.WithError(err).Error("Failed to disconnect from MongoDB")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// resetShutdown restores the shutdown state a test's call to shutdown
+// leaves behind.
+func resetShutdown(t *testing.T) {
+	t.Helper()
+	previousOutput := logger.Out
+	logger.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		shuttingDown.Store(false)
+		backgroundCtx, stopBackground = context.WithCancel(context.Background())
+		logger.SetOutput(previousOutput)
+	})
+}
+
+// serve starts server on a free local port and returns its base URL.
+func serve(t *testing.T, server *http.Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + listener.Addr().String()
+}
+
+func TestShutdownDrainsRequestsAndBackgroundWork(t *testing.T) {
+	resetShutdown(t)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var workerDone atomic.Bool
+	server := newServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goBackground(func() {
+			<-backgroundCtx.Done()
+			workerDone.Store(true)
+		})
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	url := serve(t, server)
+
+	responses := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		responses <- err
+	}()
+	<-started
+
+	// The signal arrives while the request is still in flight
+	quit := make(chan os.Signal, 1)
+	var disconnectedAfterWorker atomic.Bool
+	finished := make(chan error, 1)
+	go func() {
+		<-quit
+		finished <- shutdown(server, func(context.Context) error {
+			disconnectedAfterWorker.Store(workerDone.Load())
+			return nil
+		})
+	}()
+	quit <- syscall.SIGTERM
+	for !shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if err := <-responses; err != nil {
+		t.Fatalf("in-flight request: %v", err)
+	}
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatalf("shutdown() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	if !disconnectedAfterWorker.Load() {
+		t.Error("disconnected before background work finished")
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("server still accepting requests after shutdown")
+	}
+}
+
+func TestShutdownFailsReadinessWhileDraining(t *testing.T) {
+	t.Setenv("SHUTDOWN_DRAIN_DELAY", "500ms")
+	api := newTestAPI(t)
+	resetShutdown(t)
+	server := newServer("", api.router)
+	url := serve(t, server)
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- shutdown(server, func(context.Context) error { return nil })
+	}()
+	for !shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Still serving during the drain delay, but no longer ready
+	resp, err := http.Get(url + "/ready")
+	if err != nil {
+		t.Fatalf("readiness probe during drain: %v", err)
+	}
+	var body struct {
+		Status string `json:"status"`
+		Ready  bool   `json:"ready"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || body.Ready || body.Status != "shutting down" {
+		t.Errorf("readiness = %d %+v, want 503 shutting down", resp.StatusCode, body)
+	}
+
+	if err := <-finished; err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+}